@@ -0,0 +1,184 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Caching of loaded objects and decoded object streams.
+
+package pdf
+
+import (
+	"bytes"
+	"container/list"
+	"io/ioutil"
+	"sync"
+)
+
+const (
+	// defaultCacheSize is the number of objects a Reader caches by default.
+	defaultCacheSize = 1000
+
+	// defaultObjStmCacheSize is the number of bytes of decoded
+	// object streams a Reader caches by default.
+	defaultObjStmCacheSize = 32 << 20
+)
+
+// An objCache is a bounded cache of loaded indirect objects
+// or decoded object streams, evicting the least recently used
+// entries when the total cost of its entries exceeds max.
+// It is safe for concurrent use.
+type objCache struct {
+	mu   sync.Mutex
+	max  int
+	used int        // total cost of entries
+	lru  *list.List // of *cacheEntry, most recently used first
+	m    map[objptr]*list.Element
+}
+
+type cacheEntry struct {
+	ptr  objptr
+	obj  interface{}
+	cost int
+}
+
+func newObjCache(max int) *objCache {
+	return &objCache{
+		max: max,
+		lru: list.New(),
+		m:   make(map[objptr]*list.Element),
+	}
+}
+
+func (c *objCache) get(ptr objptr) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.m[ptr]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cacheEntry).obj, true
+}
+
+// add adds obj to the cache as the entry for ptr, with the given cost.
+func (c *objCache) add(ptr objptr, obj interface{}, cost int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.max <= 0 {
+		return
+	}
+	if e, ok := c.m[ptr]; ok {
+		ce := e.Value.(*cacheEntry)
+		c.used += cost - ce.cost
+		ce.obj, ce.cost = obj, cost
+		c.lru.MoveToFront(e)
+	} else {
+		c.m[ptr] = c.lru.PushFront(&cacheEntry{ptr, obj, cost})
+		c.used += cost
+	}
+	c.trim()
+}
+
+func (c *objCache) setMax(max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.max = max
+	c.trim()
+}
+
+func (c *objCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.max
+}
+
+// trim evicts entries until the cache is within its size limit.
+// c.mu must be held.
+func (c *objCache) trim() {
+	for c.lru.Len() > 0 && (c.used > c.max || c.max <= 0) {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		ce := e.Value.(*cacheEntry)
+		delete(c.m, ce.ptr)
+		c.used -= ce.cost
+	}
+}
+
+// SetCacheSize sets the maximum number of indirect objects that r keeps
+// in memory after loading them, so that dereferencing the same object
+// again does not reread and reparse the file.
+// A size of zero or less disables the cache.
+// Decoded object streams are cached separately (see SetObjStmCacheSize).
+func (r *Reader) SetCacheSize(n int) {
+	r.cache.setMax(n)
+}
+
+// SetObjStmCacheSize sets the maximum number of bytes of decoded
+// object stream data that r keeps in memory, so that loading
+// another object from the same object stream does not decode it again.
+// A size of zero or less disables the cache.
+func (r *Reader) SetObjStmCacheSize(n int) {
+	r.stmCache.setMax(n)
+}
+
+// An objStm is the decoded form of an object stream (type ObjStm).
+type objStm struct {
+	data    []byte           // decoded stream data
+	offsets map[uint32]int64 // offset in data of each object
+	extends objptr           // object stream extended by this one, if any
+}
+
+// objStm returns the decoded object stream ptr, decoding it
+// and indexing its objects on first use.
+// It panics with an *Error if the stream is malformed.
+func (r *Reader) objStm(ptr objptr) *objStm {
+	if stm, ok := r.stmCache.get(ptr); ok {
+		return stm.(*objStm)
+	}
+
+	strm := r.resolve(objptr{}, ptr)
+	if err := strm.Err(); err != nil {
+		panic(err)
+	}
+	if strm.Kind() != Stream {
+		r.errorf("not a stream")
+	}
+	if strm.Key("Type").Name() != "ObjStm" {
+		r.errorf("not an object stream")
+	}
+	n := int(strm.Key("N").Int64())
+	first := strm.Key("First").Int64()
+	if first == 0 {
+		r.errorf("missing First")
+	}
+	rd, err := strm.ReaderErr()
+	if err != nil {
+		panic(err)
+	}
+	data, err := ioutil.ReadAll(r.limitBuffered(strm.data.(stream), rd))
+	if err != nil {
+		panic(toError(err, ptr, -1))
+	}
+
+	stm := &objStm{
+		data:    data,
+		offsets: make(map[uint32]int64),
+	}
+	b := newBuffer(bytes.NewReader(data), 0)
+	b.allowEOF = true
+	for i := 0; i < n; i++ {
+		id, ok1 := b.readToken().(int64)
+		off, ok2 := b.readToken().(int64)
+		if !ok1 || !ok2 || int64(uint32(id)) != id || off < 0 || first+off > int64(len(data)) {
+			r.errorf("malformed object stream index")
+		}
+		if _, ok := stm.offsets[uint32(id)]; !ok {
+			stm.offsets[uint32(id)] = first + off
+		}
+	}
+	if ext, ok := strm.data.(stream).hdr["Extends"].(objptr); ok {
+		stm.extends = ext
+	}
+
+	r.stmCache.add(ptr, stm, len(data))
+	return stm
+}
@@ -0,0 +1,891 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Decryption of encrypted files, and the standard security handler.
+
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rc4"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A cryptMethod is a method of encrypting strings and streams.
+type cryptMethod int
+
+const (
+	cryptIdentity cryptMethod = iota // no encryption
+	cryptRC4                         // RC4, with a key derived for each object
+	cryptAESV2                       // AES-128 in CBC mode, with a key derived for each object
+	cryptAESV3                       // AES-256 in CBC mode, using the file key directly
+)
+
+// A cryptFilter is a crypt filter defined by an encryption dictionary.
+type cryptFilter struct {
+	method cryptMethod
+	bits   int // key length in bits, or 0 if not given
+}
+
+var passwordPad = []byte{
+	0x28, 0xBF, 0x4E, 0x5E, 0x4E, 0x75, 0x8A, 0x41, 0x64, 0x00, 0x4E, 0x56, 0xFF, 0xFA, 0x01, 0x08,
+	0x2E, 0x2E, 0x00, 0xB6, 0xD0, 0x68, 0x3E, 0x80, 0x2F, 0x0C, 0xA9, 0xFE, 0x64, 0x53, 0x69, 0x7A,
+}
+
+// initEncrypt sets up decryption of an encrypted file,
+// trying the passwords supplied by opts.
+func (r *Reader) initEncrypt(opts *ReaderOptions) error {
+	// See PDF 32000-1:2008, §7.6.
+	enc := r.resolve(objptr{}, r.trailer["Encrypt"])
+	encrypt, _ := enc.data.(dict)
+	V, _ := encrypt["V"].(int64)
+	if V != 1 && V != 2 && V != 4 && V != 5 {
+		return fmt.Errorf("unsupported PDF: encryption version V=%d; %v", V, objfmt(encrypt))
+	}
+
+	filters, stm, str, err := defaultCrypts(enc, V)
+	if err != nil {
+		return err
+	}
+
+	var key []byte
+	var perms Permissions
+	var kind PasswordKind
+	switch encrypt["Filter"] {
+	default:
+		return fmt.Errorf("unsupported PDF: encryption filter %v", objfmt(encrypt["Filter"]))
+
+	case name("Standard"):
+		e, err := newEncryptionParams(encrypt, r.trailer, keyFilter(stm, str))
+		if err != nil {
+			return err
+		}
+		if opts.FileKey != nil {
+			if e.CheckKey(opts.FileKey) {
+				key = append([]byte(nil), opts.FileKey...)
+			}
+		} else {
+			key, kind = e.authenticate(opts)
+		}
+		if key == nil {
+			return ErrInvalidPassword
+		}
+		if e.R >= 5 {
+			if err := e.checkPerms(key); err != nil {
+				if r.strict {
+					return err
+				}
+				enc.warnf("encryption", "%v", err)
+			}
+		}
+		perms = e.permissions()
+
+	case name("Adobe.PubSec"):
+		if key, perms, err = pubSecKey(enc, V, keyFilter(stm, str), opts); err != nil {
+			return err
+		}
+	}
+
+	r.key = key
+	r.stmCrypt = stm.method
+	r.strCrypt = str.method
+	r.cryptFilters = filters
+	r.encryptMetadata = encryptMetadata(enc)
+	r.perms = perms
+	r.passwordKind = kind
+	return nil
+}
+
+// EncryptionParams holds the parameters that the standard security handler
+// uses to check passwords, as found in an encrypted file's encryption
+// dictionary and trailer. They are enough to test passwords
+// without reading the file again.
+type EncryptionParams struct {
+	V, R            int    // encryption algorithm version and revision
+	Length          int    // key length in bits
+	P               int32  // permission flags
+	EncryptMetadata bool   // whether the metadata stream is encrypted
+	ID              []byte // first element of the trailer's ID array
+	O, U            []byte // owner and user password hashes
+	OE, UE          []byte // encrypted file keys (R ≥ 5 only)
+	Perms           []byte // encrypted permissions (R ≥ 5 only)
+}
+
+// ReadEncryptionParams reads the password parameters of the encrypted
+// PDF file in f, which has the given total size, without needing a password.
+// The file must use the standard security handler.
+func ReadEncryptionParams(f io.ReaderAt, size int64) (_ *EncryptionParams, err error) {
+	defer catch(&err, objptr{}, -1)
+
+	r, err := newReader(context.Background(), f, size, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.trailer["Encrypt"] == nil {
+		return nil, fmt.Errorf("PDF is not encrypted")
+	}
+	enc := r.resolve(objptr{}, r.trailer["Encrypt"])
+	encrypt, _ := enc.data.(dict)
+	if encrypt["Filter"] != name("Standard") {
+		return nil, fmt.Errorf("unsupported PDF: encryption filter %v", objfmt(encrypt["Filter"]))
+	}
+	V, _ := encrypt["V"].(int64)
+	_, stm, str, err := defaultCrypts(enc, V)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptionParams(encrypt, r.trailer, keyFilter(stm, str))
+}
+
+// newEncryptionParams returns the parameters given by the encryption
+// dictionary encrypt and the trailer. For V=4, the key length is
+// that of cf, the crypt filter returned by keyFilter.
+func newEncryptionParams(encrypt, trailer dict, cf cryptFilter) (*EncryptionParams, error) {
+	V, _ := encrypt["V"].(int64)
+	R, _ := encrypt["R"].(int64)
+	P, _ := encrypt["P"].(int64)
+	n, _ := encrypt["Length"].(int64)
+	O, _ := encrypt["O"].(string)
+	U, _ := encrypt["U"].(string)
+	OE, _ := encrypt["OE"].(string)
+	UE, _ := encrypt["UE"].(string)
+	perms, _ := encrypt["Perms"].(string)
+	e := &EncryptionParams{
+		V:               int(V),
+		R:               int(R),
+		Length:          int(n),
+		P:               int32(P),
+		EncryptMetadata: encrypt["EncryptMetadata"] != false,
+		O:               []byte(O),
+		U:               []byte(U),
+		OE:              []byte(OE),
+		UE:              []byte(UE),
+		Perms:           []byte(perms),
+	}
+	if ids, ok := trailer["ID"].(array); ok && len(ids) >= 1 {
+		id, _ := ids[0].(string)
+		e.ID = []byte(id)
+	}
+	if e.V == 4 {
+		switch {
+		case cf.method == cryptAESV2:
+			e.Length = 128
+		case cf.bits != 0:
+			e.Length = cf.bits
+		}
+	}
+	if e.Length == 0 {
+		e.Length = 40
+	}
+	if e.R >= 5 {
+		e.Length = 256
+	}
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// check reports whether e holds a usable set of parameters,
+// trimming O and U to the length used by R ≥ 5.
+func (e *EncryptionParams) check() error {
+	if e.R < 2 {
+		return fmt.Errorf("malformed PDF: encryption revision R=%d", e.R)
+	}
+	if e.R > 6 {
+		return fmt.Errorf("unsupported PDF: encryption revision R=%d", e.R)
+	}
+	if (e.V == 5) != (e.R >= 5) {
+		return fmt.Errorf("malformed PDF: encryption revision R=%d with V=%d", e.R, e.V)
+	}
+	if e.R >= 5 {
+		// See ISO 32000-2:2017, §7.6.4.3.3 and §7.6.4.4.
+		if len(e.O) < 48 || len(e.U) < 48 || len(e.OE) != 32 || len(e.UE) != 32 {
+			return fmt.Errorf("malformed PDF: missing O=, U=, OE= or UE= encryption parameters")
+		}
+		e.O, e.U = e.O[:48], e.U[:48]
+		return nil
+	}
+	if e.Length%8 != 0 || e.Length > 128 || e.Length < 40 {
+		return fmt.Errorf("malformed PDF: %d-bit encryption key", e.Length)
+	}
+	if len(e.ID) == 0 {
+		return fmt.Errorf("malformed PDF: missing ID in trailer")
+	}
+	if len(e.O) != 32 || len(e.U) != 32 {
+		return fmt.Errorf("malformed PDF: missing O= or U= encryption parameters")
+	}
+	return nil
+}
+
+// keyLen returns the length of the file key in bytes, for R < 5.
+func (e *EncryptionParams) keyLen() int {
+	if e.R == 2 {
+		return 40 / 8
+	}
+	return e.Length / 8
+}
+
+// CheckUserPassword reports whether password is the user password.
+func (e *EncryptionParams) CheckUserPassword(password string) bool {
+	for _, pw := range passwordBytes(e.R, password) {
+		if e.userKey(pw) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOwnerPassword reports whether password is the owner password.
+func (e *EncryptionParams) CheckOwnerPassword(password string) bool {
+	for _, pw := range passwordBytes(e.R, password) {
+		if e.ownerKey(pw) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the parameters in the form of a single-line hash,
+// in the format used by password recovery tools such as hashcat:
+//
+//	$pdf$V*R*Length*P*EncryptMetadata*len(ID)*ID*len(U)*U*len(O)*O
+//
+// followed, for R ≥ 5, by *len(UE)*UE*len(OE)*OE.
+// Byte strings are written in hexadecimal.
+func (e *EncryptionParams) String() string {
+	meta := 0
+	if e.EncryptMetadata {
+		meta = 1
+	}
+	s := fmt.Sprintf("$pdf$%d*%d*%d*%d*%d", e.V, e.R, e.Length, e.P, meta)
+	fields := [][]byte{e.ID, e.U, e.O}
+	if e.R >= 5 {
+		fields = append(fields, e.UE, e.OE)
+	}
+	for _, f := range fields {
+		s += fmt.Sprintf("*%d*%x", len(f), f)
+	}
+	return s
+}
+
+// ParseEncryptionParams parses a hash in the format written by
+// EncryptionParams.String.
+func ParseEncryptionParams(hash string) (*EncryptionParams, error) {
+	f := strings.Split(strings.TrimSpace(hash), "*")
+	if !strings.HasPrefix(f[0], "$pdf$") || len(f) != 11 && len(f) != 15 {
+		return nil, fmt.Errorf("invalid PDF hash %q", hash)
+	}
+	f[0] = strings.TrimPrefix(f[0], "$pdf$")
+	var num [5]int
+	for i := range num {
+		n, err := strconv.Atoi(f[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid PDF hash %q", hash)
+		}
+		num[i] = n
+	}
+	var bufs [][]byte
+	for i := 5; i+1 < len(f); i += 2 {
+		n, err := strconv.Atoi(f[i])
+		b, err1 := hex.DecodeString(f[i+1])
+		if err != nil || err1 != nil || len(b) != n {
+			return nil, fmt.Errorf("invalid PDF hash %q", hash)
+		}
+		bufs = append(bufs, b)
+	}
+	e := &EncryptionParams{
+		V:               num[0],
+		R:               num[1],
+		Length:          num[2],
+		P:               int32(num[3]),
+		EncryptMetadata: num[4] != 0,
+		ID:              bufs[0],
+		U:               bufs[1],
+		O:               bufs[2],
+	}
+	if len(bufs) > 3 {
+		e.UE, e.OE = bufs[3], bufs[4]
+	}
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// authenticate tries the passwords supplied by opts, returning the
+// file key and the kind of the password that produced it,
+// or a nil key if none of the passwords is correct.
+// If the empty password opens the file as the user password,
+// the passwords in opts.Passwords are still tried as owner passwords.
+func (e *EncryptionParams) authenticate(opts *ReaderOptions) (key []byte, kind PasswordKind) {
+	try := func(password string) bool {
+		for _, pw := range passwordBytes(e.R, password) {
+			if k := e.ownerKey(pw); k != nil {
+				key, kind = k, OwnerPassword
+				return true
+			}
+			if key == nil {
+				if k := e.userKey(pw); k != nil {
+					key, kind = k, UserPassword
+				}
+			}
+		}
+		return false
+	}
+	if try("") {
+		return
+	}
+	for _, pw := range opts.Passwords {
+		if try(pw) {
+			return
+		}
+	}
+	if key != nil || opts.Password == nil {
+		return
+	}
+	for {
+		pw := opts.Password()
+		if pw == "" || try(pw) || key != nil {
+			return
+		}
+	}
+}
+
+// userKey returns the file key if pw is the user password, or else nil.
+func (e *EncryptionParams) userKey(pw []byte) []byte {
+	if e.R >= 5 {
+		if !bytes.Equal(hashV5(e.R, pw, e.U[32:40], nil), e.U[:32]) {
+			return nil
+		}
+		return decryptKeyV5(hashV5(e.R, pw, e.U[40:48], nil), e.UE)
+	}
+
+	// See PDF 32000-1:2008, algorithms 2, 4, 5 and 6.
+	h := md5.New()
+	if len(pw) >= 32 {
+		h.Write(pw[:32])
+	} else {
+		h.Write(pw)
+		h.Write(passwordPad[:32-len(pw)])
+	}
+	h.Write(e.O)
+	h.Write([]byte{byte(e.P), byte(e.P >> 8), byte(e.P >> 16), byte(e.P >> 24)})
+	h.Write(e.ID)
+	if e.R >= 4 && !e.EncryptMetadata {
+		h.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	}
+	key := h.Sum(nil)
+
+	if e.R >= 3 {
+		for i := 0; i < 50; i++ {
+			h.Reset()
+			h.Write(key[:e.keyLen()])
+			key = h.Sum(key[:0])
+		}
+	}
+	key = key[:e.keyLen()]
+	if !e.CheckKey(key) {
+		return nil
+	}
+	return key
+}
+
+// CheckKey reports whether key is the file encryption key,
+// by computing the U entry that the key implies.
+// It supports only revisions before 5, whose keys are at most 128 bits.
+func (e *EncryptionParams) CheckKey(key []byte) bool {
+	if e.R >= 5 || len(key) != e.keyLen() {
+		return false
+	}
+	c, _ := rc4.NewCipher(key)
+	var u []byte
+	if e.R == 2 {
+		u = make([]byte, 32)
+		copy(u, passwordPad)
+		c.XORKeyStream(u, u)
+	} else {
+		h := md5.New()
+		h.Write(passwordPad)
+		h.Write(e.ID)
+		u = h.Sum(nil)
+		c.XORKeyStream(u, u)
+		rc4Rounds(key, u, 1, 19)
+	}
+	return bytes.HasPrefix(e.U, u)
+}
+
+// ownerKey returns the file key if pw is the owner password, or else nil.
+func (e *EncryptionParams) ownerKey(pw []byte) []byte {
+	if e.R >= 5 {
+		if !bytes.Equal(hashV5(e.R, pw, e.O[32:40], e.U), e.O[:32]) {
+			return nil
+		}
+		return decryptKeyV5(hashV5(e.R, pw, e.O[40:48], e.U), e.OE)
+	}
+
+	// Decrypting O with a key derived from the owner password
+	// yields the padded user password (algorithm 7).
+	h := md5.New()
+	if len(pw) >= 32 {
+		h.Write(pw[:32])
+	} else {
+		h.Write(pw)
+		h.Write(passwordPad[:32-len(pw)])
+	}
+	key := h.Sum(nil)
+	if e.R >= 3 {
+		// Unlike the file key, the hash is not truncated between rounds.
+		for i := 0; i < 50; i++ {
+			h.Reset()
+			h.Write(key)
+			key = h.Sum(key[:0])
+		}
+	}
+	key = key[:e.keyLen()]
+
+	upw := make([]byte, 32)
+	copy(upw, e.O)
+	if e.R == 2 {
+		c, _ := rc4.NewCipher(key)
+		c.XORKeyStream(upw, upw)
+	} else {
+		rc4Rounds(key, upw, 19, 0)
+	}
+	return e.userKey(upw)
+}
+
+// rc4Rounds encrypts data in place with RC4 once for each i from
+// first to last (in either direction), using key XOR i as the key.
+func rc4Rounds(key, data []byte, first, last int) {
+	step := 1
+	if last < first {
+		step = -1
+	}
+	key1 := make([]byte, len(key))
+	for i := first; ; i += step {
+		for j := range key1 {
+			key1[j] = key[j] ^ byte(i)
+		}
+		c, _ := rc4.NewCipher(key1)
+		c.XORKeyStream(data, data)
+		if i == last {
+			break
+		}
+	}
+}
+
+// hashV5 computes the password hash used by revisions 5 and 6
+// of the standard security handler (ISO 32000-2:2017, algorithm 2.B).
+// Revision 5 uses a single round of SHA-256.
+func hashV5(R int, pw, salt, udata []byte) []byte {
+	h := sha256.New()
+	h.Write(pw)
+	h.Write(salt)
+	h.Write(udata)
+	k := h.Sum(nil)
+	if R == 5 {
+		return k
+	}
+
+	var e []byte
+	for i := 0; i < 64 || int(e[len(e)-1]) > i-32; i++ {
+		k1 := make([]byte, 0, 64*(len(pw)+len(k)+len(udata)))
+		for j := 0; j < 64; j++ {
+			k1 = append(k1, pw...)
+			k1 = append(k1, k...)
+			k1 = append(k1, udata...)
+		}
+		c, _ := aes.NewCipher(k[:16])
+		cipher.NewCBCEncrypter(c, k[16:32]).CryptBlocks(k1, k1)
+		e = k1
+
+		// The first 16 bytes of e, taken as a big-endian number mod 3,
+		// select the next hash function. Since 256 ≡ 1 mod 3,
+		// that is the sum of the bytes mod 3.
+		sum := 0
+		for _, b := range e[:16] {
+			sum += int(b)
+		}
+		switch sum % 3 {
+		case 0:
+			s := sha256.Sum256(e)
+			k = s[:]
+		case 1:
+			s := sha512.Sum384(e)
+			k = s[:]
+		case 2:
+			s := sha512.Sum512(e)
+			k = s[:]
+		}
+	}
+	return k[:32]
+}
+
+// decryptKeyV5 decrypts the file key stored in UE or OE,
+// using the key derived from the password.
+func decryptKeyV5(hash, enc []byte) []byte {
+	c, _ := aes.NewCipher(hash)
+	key := make([]byte, 32)
+	cipher.NewCBCDecrypter(c, make([]byte, aes.BlockSize)).CryptBlocks(key, enc)
+	return key
+}
+
+// checkPerms verifies that the Perms entry, encrypted with the file key,
+// agrees with the P and EncryptMetadata entries of the encryption dictionary.
+func (e *EncryptionParams) checkPerms(key []byte) error {
+	if len(e.Perms) != aes.BlockSize {
+		return fmt.Errorf("malformed PDF: missing Perms= encryption parameter")
+	}
+	c, _ := aes.NewCipher(key)
+	buf := make([]byte, aes.BlockSize)
+	c.Decrypt(buf, e.Perms)
+	if string(buf[9:12]) != "adb" {
+		return fmt.Errorf("malformed PDF: Perms= does not match file key")
+	}
+	if binary.LittleEndian.Uint32(buf) != uint32(e.P) {
+		return fmt.Errorf("malformed PDF: Perms= does not match P=")
+	}
+	meta := byte('T')
+	if !e.EncryptMetadata {
+		meta = 'F'
+	}
+	if buf[8] != meta {
+		return fmt.Errorf("malformed PDF: Perms= does not match EncryptMetadata=")
+	}
+	return nil
+}
+
+// permissions returns the permissions granted by P.
+// Revision 2 has fewer permission bits; each of the later bits
+// is implied by the revision 2 bit that covered it.
+func (e *EncryptionParams) permissions() Permissions {
+	p := Permissions(e.P) & allPermissions
+	if e.R == 2 {
+		p &^= PermFillForms | PermExtractAccessibility | PermAssemble | PermPrintHighQuality
+		if p.Has(PermAnnotate) {
+			p |= PermFillForms
+		}
+		if p.Has(PermCopy) {
+			p |= PermExtractAccessibility
+		}
+		if p.Has(PermModify) {
+			p |= PermAssemble
+		}
+		if p.Has(PermPrint) {
+			p |= PermPrintHighQuality
+		}
+	}
+	return p
+}
+
+// A PasswordKind identifies the password that opened an encrypted file.
+type PasswordKind int
+
+const (
+	NoPassword    PasswordKind = iota // the file is not encrypted, or was opened with a certificate or file key
+	UserPassword                      // the user password, which may be empty
+	OwnerPassword                     // the owner password
+)
+
+func (k PasswordKind) String() string {
+	switch k {
+	case NoPassword:
+		return "none"
+	case UserPassword:
+		return "user"
+	case OwnerPassword:
+		return "owner"
+	}
+	return fmt.Sprintf("PasswordKind(%d)", int(k))
+}
+
+// PasswordKind reports which password opened r.
+func (r *Reader) PasswordKind() PasswordKind {
+	return r.passwordKind
+}
+
+// Permissions is a set of operations on a document,
+// as recorded in the P entry of its encryption dictionary
+// (PDF 32000-1:2008, Table 22).
+type Permissions uint32
+
+const (
+	PermPrint                Permissions = 1 << 2  // print the document
+	PermModify               Permissions = 1 << 3  // modify the contents
+	PermCopy                 Permissions = 1 << 4  // copy or extract text and graphics
+	PermAnnotate             Permissions = 1 << 5  // add or modify annotations and fill in forms
+	PermFillForms            Permissions = 1 << 8  // fill in existing form fields
+	PermExtractAccessibility Permissions = 1 << 9  // extract text and graphics for accessibility
+	PermAssemble             Permissions = 1 << 10 // insert, rotate or delete pages
+	PermPrintHighQuality     Permissions = 1 << 11 // print at full quality
+
+	allPermissions = PermPrint | PermModify | PermCopy | PermAnnotate |
+		PermFillForms | PermExtractAccessibility | PermAssemble | PermPrintHighQuality
+)
+
+// Has reports whether p includes all the permissions in q.
+func (p Permissions) Has(q Permissions) bool {
+	return p&q == q
+}
+
+// Permissions returns the operations that the document's author permits.
+// If the file is not encrypted, all operations are permitted.
+// The restrictions are advisory: the owner password grants
+// full access, and this package does not enforce them.
+func (r *Reader) Permissions() Permissions {
+	if r.key == nil {
+		return allPermissions
+	}
+	return r.perms
+}
+
+var ErrInvalidPassword = fmt.Errorf("encrypted PDF: invalid password")
+
+// defaultCrypts returns the crypt filters defined by the encryption
+// dictionary encrypt, and the filters used by default for streams and strings.
+// Before V=4, all streams and strings use RC4.
+func defaultCrypts(encrypt Value, V int64) (filters map[name]cryptFilter, stm, str cryptFilter, err error) {
+	if V < 4 {
+		rc4 := cryptFilter{method: cryptRC4}
+		return map[name]cryptFilter{"Identity": {}}, rc4, rc4, nil
+	}
+	if filters, err = cryptFilters(encrypt, V); err != nil {
+		return
+	}
+	d, _ := encrypt.data.(dict)
+	if stm, err = defaultCrypt(d, "StmF", filters); err != nil {
+		return
+	}
+	str, err = defaultCrypt(d, "StrF", filters)
+	return
+}
+
+// keyFilter returns the crypt filter that gives the key length:
+// the one for streams, unless streams are not encrypted.
+func keyFilter(stm, str cryptFilter) cryptFilter {
+	if stm.method == cryptIdentity {
+		return str
+	}
+	return stm
+}
+
+// cryptFilters returns the crypt filters defined in the CF entry of a
+// V=4 or V=5 encryption dictionary, along with the predefined Identity filter.
+func cryptFilters(encrypt Value, V int64) (map[name]cryptFilter, error) {
+	filters := map[name]cryptFilter{"Identity": {}}
+	cf := encrypt.Key("CF")
+	for _, n := range cf.Keys() {
+		param := cf.Key(n)
+		var m cryptMethod
+		switch cfm := param.Key("CFM").Name(); cfm {
+		case "", "None":
+			m = cryptIdentity
+		case "V2":
+			m = cryptRC4
+		case "AESV2":
+			m = cryptAESV2
+		case "AESV3":
+			m = cryptAESV3
+		default:
+			return nil, fmt.Errorf("unsupported PDF: crypt filter %s uses method %s", n, cfm)
+		}
+		if m != cryptIdentity && (m == cryptAESV3) != (V == 5) {
+			return nil, fmt.Errorf("malformed PDF: crypt filter %s uses method %s with V=%d", n, param.Key("CFM").Name(), V)
+		}
+		switch ev := param.Key("AuthEvent").Name(); ev {
+		case "", "DocOpen", "EFOpen":
+		default:
+			return nil, fmt.Errorf("unsupported PDF: crypt filter %s uses AuthEvent %s", n, ev)
+		}
+		bits := int(param.Key("Length").Int64())
+		if bits <= 16 {
+			bits *= 8 // some writers give the length in bytes
+		}
+		filters[name(n)] = cryptFilter{m, bits}
+	}
+	return filters, nil
+}
+
+// defaultCrypt returns the crypt filter named by
+// the StmF or StrF entry of the encryption dictionary.
+func defaultCrypt(encrypt dict, key name, filters map[name]cryptFilter) (cryptFilter, error) {
+	n, ok := encrypt[key].(name)
+	if !ok {
+		n = "Identity"
+	}
+	f, ok := filters[n]
+	if !ok {
+		return cryptFilter{}, fmt.Errorf("malformed PDF: %s names undefined crypt filter %s", key, n)
+	}
+	return f, nil
+}
+
+// streamCrypt returns the method used to encrypt the stream v,
+// whose filters are given. A Crypt filter, which must come first,
+// selects a crypt filter other than the document's default.
+func (r *Reader) streamCrypt(v Value, filters []Filter) (cryptMethod, error) {
+	switch v.Key("Type").Name() {
+	case "XRef":
+		return cryptIdentity, nil
+	case "Metadata":
+		if !r.encryptMetadata {
+			return cryptIdentity, nil
+		}
+	}
+	if len(filters) == 0 || filters[0].Name != "Crypt" {
+		return r.stmCrypt, nil
+	}
+	n := filters[0].Param.Key("Name").Name()
+	if n == "" {
+		n = "Identity"
+	}
+	f, ok := r.cryptFilters[name(n)]
+	if !ok {
+		return 0, fmt.Errorf("undefined crypt filter %s", n)
+	}
+	return f.method, nil
+}
+
+// cryptKey returns the key used to encrypt the strings and streams of
+// object ptr (PDF 32000-1:2008, algorithm 1).
+func cryptKey(key []byte, crypt cryptMethod, ptr objptr) []byte {
+	if crypt == cryptAESV3 {
+		return key
+	}
+	h := md5.New()
+	h.Write(key)
+	h.Write([]byte{byte(ptr.id), byte(ptr.id >> 8), byte(ptr.id >> 16), byte(ptr.gen), byte(ptr.gen >> 8)})
+	if crypt == cryptAESV2 {
+		h.Write([]byte("sAlT"))
+	}
+	n := len(key) + 5
+	if n > 16 {
+		n = 16
+	}
+	return h.Sum(nil)[:n]
+}
+
+func decryptString(key []byte, crypt cryptMethod, ptr objptr, x string) (string, error) {
+	switch crypt {
+	case cryptIdentity:
+		return x, nil
+	case cryptRC4:
+		c, _ := rc4.NewCipher(cryptKey(key, crypt, ptr))
+		data := []byte(x)
+		c.XORKeyStream(data, data)
+		return string(data), nil
+	}
+
+	// An AES-encrypted string is a 16-byte initialization vector
+	// followed by the padded ciphertext.
+	if len(x) == 0 {
+		return x, nil
+	}
+	if len(x)%aes.BlockSize != 0 {
+		return "", fmt.Errorf("malformed PDF: AES-encrypted string of length %d", len(x))
+	}
+	cb, err := aes.NewCipher(cryptKey(key, crypt, ptr))
+	if err != nil {
+		return "", fmt.Errorf("AES: %v", err)
+	}
+	data := []byte(x[aes.BlockSize:])
+	cipher.NewCBCDecrypter(cb, []byte(x[:aes.BlockSize])).CryptBlocks(data, data)
+	return string(unpad(data)), nil
+}
+
+func decryptStream(key []byte, crypt cryptMethod, ptr objptr, rd io.Reader) (io.Reader, error) {
+	switch crypt {
+	case cryptIdentity:
+		return rd, nil
+	case cryptRC4:
+		c, _ := rc4.NewCipher(cryptKey(key, crypt, ptr))
+		return &cipher.StreamReader{S: c, R: rd}, nil
+	}
+	cb, err := aes.NewCipher(cryptKey(key, crypt, ptr))
+	if err != nil {
+		return nil, fmt.Errorf("AES: %v", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	io.ReadFull(rd, iv)
+	cbc := cipher.NewCBCDecrypter(cb, iv)
+	return &cbcReader{cbc: cbc, rd: rd, buf: make([]byte, 4096)}, nil
+}
+
+// unpad removes the PKCS#5 padding from the end of data,
+// if it is present.
+func unpad(data []byte) []byte {
+	n := len(data)
+	if n == 0 {
+		return data
+	}
+	p := int(data[n-1])
+	if p < 1 || p > aes.BlockSize || p > n {
+		return data
+	}
+	return data[:n-p]
+}
+
+// A cbcReader decrypts data encrypted with AES in CBC mode.
+// It withholds the last block read until it knows whether
+// that block is the final one, whose padding must be removed.
+type cbcReader struct {
+	cbc  cipher.BlockMode
+	rd   io.Reader
+	buf  []byte
+	pend []byte
+	hold [aes.BlockSize]byte
+	held bool
+	err  error
+}
+
+func (r *cbcReader) Read(b []byte) (n int, err error) {
+	for len(r.pend) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+	}
+	n = copy(b, r.pend)
+	r.pend = r.pend[n:]
+	return n, nil
+}
+
+func (r *cbcReader) fill() {
+	n := 0
+	if r.held {
+		n = copy(r.buf, r.hold[:])
+	}
+	m, err := io.ReadFull(r.rd, r.buf[n:])
+	m -= m % aes.BlockSize
+	r.cbc.CryptBlocks(r.buf[n:n+m], r.buf[n:n+m])
+	n += m
+	if err != nil {
+		// The data has ended (ignoring any partial block),
+		// so the last block holds the padding.
+		r.held = false
+		r.pend = r.buf[:n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			r.pend = unpad(r.pend)
+			err = io.EOF
+		}
+		r.err = err
+		return
+	}
+	n -= aes.BlockSize
+	copy(r.hold[:], r.buf[n:])
+	r.held = true
+	r.pend = r.buf[:n]
+}
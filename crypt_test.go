@@ -0,0 +1,180 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdf
+
+import (
+	"bytes"
+	"crypto/rc4"
+	"fmt"
+	"testing"
+)
+
+var passwordTests = []struct {
+	name  string
+	hash  string
+	user  string
+	owner string // "" if unknown
+}{
+	{
+		// Example hash from hashcat (mode 10400).
+		name: "R2 hashcat",
+		hash: "$pdf$1*2*40*-1*0*16*51726437280452826511473255744374*32*9b09be05c226214fa1178342673d86f273602b95104f2384b6c9b709b2cbc058*32*0000000000000000000000000000000000000000000000000000000000000000",
+		user: "hashcat",
+	},
+	{
+		// Example hash from hashcat (mode 10500).
+		name:  "R3 128-bit hashcat",
+		hash:  "$pdf$2*3*128*-1028*1*16*da42ee15d4b3e08fe5b9ecea0e02ad0f*32*c9b59d72c7c670c42eeb4fca1d2ca15000000000000000000000000000000000*32*c4ff3e868dc87604626c2b8c259297a14d58c6309c70b00afdfb1fbba10ee571",
+		user:  "hashcat",
+		owner: "hashcat",
+	},
+	{
+		name:  "R2",
+		hash:  "$pdf$1*2*40*-3904*1*16*30313233343536373839616263646566*32*5f591a47b0720aba0b98bd35cdc03f9fef0c26aab2677052a2311b569d26fb47*32*94e8094419662a774442fb072e3d9f19e9d130ec09a4d0061e78fe920f7ab62f",
+		user:  "user",
+		owner: "owner",
+	},
+	{
+		name:  "R3 40-bit",
+		hash:  "$pdf$2*3*40*-3904*1*16*30313233343536373839616263646566*32*a48247aec0b31dc44349e73378b3d2c54420823cfde6f1c26b30f90ec7dd01e4*32*3c482162008fafcb228b7db3c43a1090bc5b56e9b1556e89fc0656fd291f4908",
+		user:  "user",
+		owner: "owner",
+	},
+	{
+		name:  "R4 128-bit",
+		hash:  "$pdf$4*4*128*-3904*1*16*30313233343536373839616263646566*32*7443054f26f45bb262048d46fc50eef2887534a20f0b0d04c36ed80e71e0fd77*32*0ba3835f88f90388e74e54584125ce142be0de24c6b0d37746e075b891756671",
+		user:  "user",
+		owner: "owner",
+	},
+	{
+		name:  "R6",
+		hash:  "$pdf$5*6*256*-3904*1*16*30313233343536373839616263646566*48*1de0e396a6b715b6d21e013a3c5c0e6810ad98747c8317a18e08bf36ecd83b14fa0ff0169dc9575674066676cfb0b4eb*48*851a41f97578e71b6c16623e82a5ab8a0401d95fd713da6119f073e4ce594a2e8902c44269da1cf6ba66d3f8b6d4b100*32*d094e5f97f6e008842b584dd9341752ea8a253de3192c6e2e3d321c8b9d637eb*32*ba779ca634e52363ce71900bfe18f2405ec6cda9bff285ae782030a494914d53",
+		user:  "user",
+		owner: "owner",
+	},
+}
+
+func TestPasswords(t *testing.T) {
+	for _, tt := range passwordTests {
+		e, err := ParseEncryptionParams(tt.hash)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if s := e.String(); s != tt.hash {
+			t.Errorf("%s: String() = %s, want %s", tt.name, s, tt.hash)
+		}
+		if !e.CheckUserPassword(tt.user) {
+			t.Errorf("%s: CheckUserPassword(%q) = false, want true", tt.name, tt.user)
+		}
+		if e.CheckUserPassword(tt.user + "x") {
+			t.Errorf("%s: CheckUserPassword(%q) = true, want false", tt.name, tt.user+"x")
+		}
+		if tt.owner == "" {
+			continue
+		}
+		if !e.CheckOwnerPassword(tt.owner) {
+			t.Errorf("%s: CheckOwnerPassword(%q) = false, want true", tt.name, tt.owner)
+		}
+		if e.CheckOwnerPassword(tt.owner + "x") {
+			t.Errorf("%s: CheckOwnerPassword(%q) = true, want false", tt.name, tt.owner+"x")
+		}
+		if tt.owner != tt.user && e.CheckOwnerPassword(tt.user) {
+			t.Errorf("%s: CheckOwnerPassword(%q) = true, want false", tt.name, tt.user)
+		}
+	}
+}
+
+var keyLengthTests = []struct {
+	encrypt string // entries of the encryption dictionary
+	length  int
+}{
+	{"/V 2 /R 3", 40},
+	{"/V 2 /R 3 /Length 128", 128},
+	{"/V 4 /R 4 /CF << /StdCF << /CFM /V2 /Length 16 >> >> /StmF /StdCF /StrF /StdCF", 128},
+	{"/V 4 /R 4 /CF << /StdCF << /CFM /V2 /Length 128 >> >> /StmF /StdCF /StrF /StdCF", 128},
+	{"/V 4 /R 4 /CF << /StdCF << /CFM /V2 /Length 5 >> >> /StmF /StdCF /StrF /StdCF", 40},
+	{"/V 4 /R 4 /Length 40 /CF << /StdCF << /CFM /V2 /Length 16 >> >> /StmF /StdCF /StrF /StdCF", 128},
+	{"/V 4 /R 4 /CF << /StdCF << /CFM /AESV2 >> >> /StmF /StdCF /StrF /StdCF", 128},
+	{"/V 4 /R 4 /CF << /StdCF << /CFM /AESV2 /Length 5 >> >> /StmF /StdCF /StrF /StdCF", 128},
+	{"/V 4 /R 4 /CF << /StdCF << /CFM /V2 /Length 16 >> >> /StmF /Identity /StrF /StdCF", 128},
+	{"/V 4 /R 4 /Length 128 /CF << /StdCF << /CFM /V2 >> >> /StmF /StdCF /StrF /StdCF", 128},
+}
+
+func TestKeyLength(t *testing.T) {
+	// The R4 128-bit vector from passwordTests.
+	const (
+		id = "30313233343536373839616263646566"
+		u  = "7443054f26f45bb262048d46fc50eef2887534a20f0b0d04c36ed80e71e0fd77"
+		o  = "0ba3835f88f90388e74e54584125ce142be0de24c6b0d37746e075b891756671"
+	)
+	for _, tt := range keyLengthTests {
+		f := newTestFile()
+		o1 := f.obj(1, testCatalog)
+		o2 := f.obj(2, "<< /Type /Pages /Kids [] /Count 0 >>")
+		o3 := f.obj(3, fmt.Sprintf("<< /Filter /Standard %s /P -3904 /O <%s> /U <%s> >>", tt.encrypt, o, u))
+		start := f.table([]xentry{
+			{0, 0, 0, 65535},
+			{1, 1, o1, 0},
+			{2, 1, o2, 0},
+			{3, 1, o3, 0},
+		}, fmt.Sprintf(" /Size 4 /Root 1 0 R /Encrypt 3 0 R /ID [<%s> <%s>]", id, id))
+		data := f.end(start)
+		e, err := ReadEncryptionParams(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.encrypt, err)
+			continue
+		}
+		if e.Length != tt.length {
+			t.Errorf("%s: Length = %d, want %d", tt.encrypt, e.Length, tt.length)
+		}
+		if e.Length == 128 && !e.CheckUserPassword("user") {
+			t.Errorf("%s: CheckUserPassword(%q) = false, want true", tt.encrypt, "user")
+		}
+	}
+}
+
+func TestFileKey(t *testing.T) {
+	// The R2 vector from passwordTests, with its 40-bit key.
+	e, err := ParseEncryptionParams(passwordTests[2].hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := e.userKey([]byte("user"))
+	if key == nil || !e.CheckKey(key) {
+		t.Fatalf("cannot find key for R2 vector")
+	}
+
+	secret := []byte("secret")
+	c, _ := rc4.NewCipher(cryptKey(key, cryptRC4, objptr{4, 0}))
+	c.XORKeyStream(secret, secret)
+
+	f := newTestFile()
+	o1 := f.obj(1, testCatalog)
+	o2 := f.obj(2, "<< /Type /Pages /Kids [] /Count 0 >>")
+	o3 := f.obj(3, fmt.Sprintf("<< /Filter /Standard /V 1 /R 2 /P %d /O <%x> /U <%x> >>", e.P, e.O, e.U))
+	o4 := f.obj(4, fmt.Sprintf("<%x>", secret))
+	start := f.table([]xentry{
+		{0, 0, 0, 65535},
+		{1, 1, o1, 0},
+		{2, 1, o2, 0},
+		{3, 1, o3, 0},
+		{4, 1, o4, 0},
+	}, fmt.Sprintf(" /Size 5 /Root 1 0 R /Encrypt 3 0 R /ID [<%x> <%x>]", e.ID, e.ID))
+	data := f.end(start)
+
+	r := openTest(t, data, &ReaderOptions{FileKey: key})
+	checkObjects(t, r, map[uint32]string{4: "secret"})
+	if k := r.PasswordKind(); k != NoPassword {
+		t.Errorf("PasswordKind() = %v, want %v", k, NoPassword)
+	}
+
+	bad := append([]byte(nil), key...)
+	bad[0]++
+	_, err = NewReaderOptions(bytes.NewReader(data), int64(len(data)), &ReaderOptions{FileKey: bad, Passwords: []string{"user"}})
+	if err != ErrInvalidPassword {
+		t.Errorf("open with wrong key: err = %v, want %v", err, ErrInvalidPassword)
+	}
+}
@@ -0,0 +1,488 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Stream filters and predictors.
+
+package pdf
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/ascii85"
+	"fmt"
+	"io"
+)
+
+// applyFilter returns a reader that decodes rd using the named filter.
+// The abbreviated names used in inline images are also accepted.
+// If max > 0, it is the limit on the size of the decoded data,
+// which bounds the memory the filter may allocate.
+func applyFilter(rd io.Reader, name string, param Value, max int64) (io.Reader, error) {
+	switch name {
+	default:
+		return nil, fmt.Errorf("unknown filter %s", name)
+	case "FlateDecode", "Fl":
+		zr, err := zlib.NewReader(rd)
+		if err != nil {
+			return nil, err
+		}
+		return newPredictReader(zr, param, max)
+	case "LZWDecode", "LZW":
+		early := int64(1)
+		if v := param.Key("EarlyChange"); v.Kind() == Integer {
+			early = v.Int64()
+		}
+		if early != 0 && early != 1 {
+			return nil, fmt.Errorf("invalid LZW EarlyChange %d", early)
+		}
+		return newPredictReader(newLZWReader(rd, int(early)), param, max)
+	case "ASCIIHexDecode", "AHx":
+		return &asciiHexReader{r: bufio.NewReader(rd)}, nil
+	case "ASCII85Decode", "A85":
+		return ascii85.NewDecoder(&ascii85Reader{r: bufio.NewReader(rd)}), nil
+	case "RunLengthDecode", "RL":
+		return &runLengthReader{r: bufio.NewReader(rd)}, nil
+	}
+}
+
+// canDecode reports whether applyFilter can decode the named filter.
+func canDecode(name string) bool {
+	switch name {
+	case "FlateDecode", "Fl",
+		"LZWDecode", "LZW",
+		"ASCIIHexDecode", "AHx",
+		"ASCII85Decode", "A85",
+		"RunLengthDecode", "RL":
+		return true
+	}
+	return false
+}
+
+// An asciiHexReader decodes the ASCIIHexDecode filter.
+type asciiHexReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (h *asciiHexReader) Read(b []byte) (int, error) {
+	n := 0
+	for n < len(b) && h.err == nil {
+		var x [2]int
+		i := 0
+		for i < 2 {
+			c, err := h.r.ReadByte()
+			if err != nil {
+				h.err = err
+				break
+			}
+			if isSpace(c) {
+				continue
+			}
+			if c == '>' {
+				h.err = io.EOF
+				break
+			}
+			if x[i] = unhex(c); x[i] < 0 {
+				h.err = fmt.Errorf("malformed ASCIIHexDecode data: %#q", rune(c))
+				break
+			}
+			i++
+		}
+		if i == 0 {
+			break
+		}
+		// A final odd digit is treated as if followed by 0.
+		b[n] = byte(x[0]<<4 | x[1])
+		n++
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, h.err
+}
+
+// An ascii85Reader passes through ASCII85Decode data,
+// dropping the optional <~ prefix and stopping at the ~> end marker,
+// which encoding/ascii85 does not understand.
+type ascii85Reader struct {
+	r     *bufio.Reader
+	start bool
+	eod   bool
+}
+
+func (a *ascii85Reader) Read(b []byte) (int, error) {
+	if !a.start {
+		a.start = true
+		if p, _ := a.r.Peek(2); string(p) == "<~" {
+			a.r.Discard(2)
+		}
+	}
+	n := 0
+	for n < len(b) && !a.eod {
+		c, err := a.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if c == '~' {
+			a.eod = true
+			break
+		}
+		b[n] = c
+		n++
+	}
+	if n == 0 && a.eod {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// A runLengthReader decodes the RunLengthDecode filter.
+type runLengthReader struct {
+	r    *bufio.Reader
+	pend []byte
+	buf  [128]byte
+	err  error
+}
+
+func (rl *runLengthReader) Read(b []byte) (int, error) {
+	for len(rl.pend) == 0 {
+		if rl.err != nil {
+			return 0, rl.err
+		}
+		c, err := rl.r.ReadByte()
+		if err != nil {
+			rl.err = err
+			continue
+		}
+		switch {
+		case c == 128:
+			rl.err = io.EOF
+		case c < 128:
+			n, err := io.ReadFull(rl.r, rl.buf[:int(c)+1])
+			if err != nil {
+				rl.err = io.EOF
+			}
+			rl.pend = rl.buf[:n]
+		default:
+			x, err := rl.r.ReadByte()
+			if err != nil {
+				rl.err = io.EOF
+				continue
+			}
+			n := 257 - int(c)
+			for i := 0; i < n; i++ {
+				rl.buf[i] = x
+			}
+			rl.pend = rl.buf[:n]
+		}
+	}
+	n := copy(b, rl.pend)
+	rl.pend = rl.pend[n:]
+	return n, nil
+}
+
+// An lzwReader decodes the LZWDecode filter.
+// PDF's LZW differs from the variants handled by compress/lzw:
+// codes are written most significant bit first, and by default
+// the code width increases one code early (EarlyChange 1).
+type lzwReader struct {
+	r     *bufio.Reader
+	early int
+	bits  uint32 // unconsumed input bits
+	nbits uint
+	width uint     // current code width, 9 to 12 bits
+	table [][]byte // string for each code
+	prev  []byte   // string for the previous code
+	pend  []byte
+	err   error
+}
+
+const (
+	lzwClear = 256
+	lzwEOD   = 257
+	lzwMax   = 4096
+)
+
+func newLZWReader(rd io.Reader, early int) *lzwReader {
+	z := &lzwReader{r: bufio.NewReader(rd), early: early}
+	z.reset()
+	return z
+}
+
+func (z *lzwReader) reset() {
+	if z.table == nil {
+		z.table = make([][]byte, 258, lzwMax)
+		for i := 0; i < 256; i++ {
+			z.table[i] = []byte{byte(i)}
+		}
+	}
+	z.table = z.table[:258]
+	z.width = 9
+	z.prev = nil
+}
+
+func (z *lzwReader) Read(b []byte) (int, error) {
+	for len(z.pend) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.step()
+	}
+	n := copy(b, z.pend)
+	z.pend = z.pend[n:]
+	return n, nil
+}
+
+// step decodes a single code.
+func (z *lzwReader) step() {
+	for z.nbits < z.width {
+		c, err := z.r.ReadByte()
+		if err != nil {
+			// Many writers omit the EOD code.
+			z.err = io.EOF
+			return
+		}
+		z.bits = z.bits<<8 | uint32(c)
+		z.nbits += 8
+	}
+	code := int(z.bits >> (z.nbits - z.width) & (1<<z.width - 1))
+	z.nbits -= z.width
+	z.bits &= 1<<z.nbits - 1
+
+	switch {
+	case code == lzwClear:
+		z.reset()
+		return
+	case code == lzwEOD:
+		z.err = io.EOF
+		return
+	}
+
+	var s []byte
+	switch {
+	case code < len(z.table) && code != lzwClear && code != lzwEOD:
+		s = z.table[code]
+	case code == len(z.table) && z.prev != nil:
+		s = append(z.prev[:len(z.prev):len(z.prev)], z.prev[0])
+	default:
+		z.err = fmt.Errorf("malformed LZW data: invalid code %d", code)
+		return
+	}
+	if z.prev != nil && len(z.table) < lzwMax {
+		z.table = append(z.table, append(z.prev[:len(z.prev):len(z.prev)], s[0]))
+	}
+	if len(z.table)+z.early >= 1<<z.width && z.width < 12 {
+		z.width++
+	}
+	z.prev = s
+	z.pend = s
+}
+
+// newPredictReader returns a reader that undoes the predictor
+// described by the decoding parameters param, as used by
+// the FlateDecode and LZWDecode filters.
+// See PDF 32000-1:2008, §7.4.4.4.
+func newPredictReader(rd io.Reader, param Value, max int64) (io.Reader, error) {
+	pred := int64(1)
+	if v := param.Key("Predictor"); v.Kind() == Integer {
+		pred = v.Int64()
+	}
+	if pred == 1 {
+		return rd, nil
+	}
+	if pred != 2 && (pred < 10 || pred > 15) {
+		return nil, fmt.Errorf("unknown predictor %d", pred)
+	}
+
+	colors, bpc, columns := int64(1), int64(8), int64(1)
+	if v := param.Key("Colors"); v.Kind() == Integer {
+		colors = v.Int64()
+	}
+	if v := param.Key("BitsPerComponent"); v.Kind() == Integer {
+		bpc = v.Int64()
+	}
+	if v := param.Key("Columns"); v.Kind() == Integer {
+		columns = v.Int64()
+	}
+	if colors < 1 || colors > 32 {
+		return nil, fmt.Errorf("invalid predictor Colors %d", colors)
+	}
+	switch bpc {
+	default:
+		return nil, fmt.Errorf("invalid predictor BitsPerComponent %d", bpc)
+	case 1, 2, 4, 8, 16:
+	}
+	const maxRowBits = 1 << 30
+	if columns < 1 || columns > maxRowBits/(colors*bpc) {
+		return nil, fmt.Errorf("invalid predictor Columns %d", columns)
+	}
+
+	rowBytes := int((colors*bpc*columns + 7) / 8)
+	if max > 0 && int64(rowBytes) > max {
+		return nil, &LimitError{"MaxStreamSize", max}
+	}
+	p := &predictReader{
+		r:      rd,
+		png:    pred >= 10,
+		colors: int(colors),
+		bpc:    int(bpc),
+		bpp:    int((colors*bpc + 7) / 8),
+		prev:   make([]byte, rowBytes),
+		row:    make([]byte, rowBytes),
+	}
+	if p.png {
+		p.tmp = make([]byte, 1+rowBytes)
+	} else {
+		p.tmp = make([]byte, rowBytes)
+	}
+	return p, nil
+}
+
+// A predictReader undoes a PNG or TIFF predictor, one row at a time.
+type predictReader struct {
+	r      io.Reader
+	png    bool // PNG predictor; otherwise TIFF predictor 2
+	colors int  // components per pixel
+	bpc    int  // bits per component
+	bpp    int  // bytes per pixel, rounded up, for PNG filters
+	prev   []byte
+	row    []byte
+	tmp    []byte // encoded row, including PNG filter type byte
+	pend   []byte
+	err    error
+}
+
+func (p *predictReader) Read(b []byte) (int, error) {
+	for len(p.pend) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		n, err := io.ReadFull(p.r, p.tmp)
+		if err == io.ErrUnexpectedEOF {
+			// Decode a truncated final row as far as it goes.
+			err = io.EOF
+		}
+		p.err = err
+		if p.png {
+			if n <= 1 {
+				continue
+			}
+			if err := p.decodePNG(p.tmp[0], p.tmp[1:n]); err != nil {
+				p.err = err
+				return 0, err
+			}
+			p.pend = p.row[:n-1]
+		} else {
+			if n == 0 {
+				continue
+			}
+			p.decodeTIFF(p.tmp[:n])
+			p.pend = p.row[:n]
+		}
+	}
+	n := copy(b, p.pend)
+	p.pend = p.pend[n:]
+	return n, nil
+}
+
+// decodePNG decodes the row enc, which was encoded with PNG filter type typ.
+func (p *predictReader) decodePNG(typ byte, enc []byte) error {
+	p.prev, p.row = p.row, p.prev
+	prev, row, bpp := p.prev, p.row, p.bpp
+	switch typ {
+	default:
+		return fmt.Errorf("malformed PNG predictor: unknown filter type %d", typ)
+	case 0: // None
+		copy(row, enc)
+	case 1: // Sub
+		for i, c := range enc {
+			if i >= bpp {
+				c += row[i-bpp]
+			}
+			row[i] = c
+		}
+	case 2: // Up
+		for i, c := range enc {
+			row[i] = c + prev[i]
+		}
+	case 3: // Average
+		for i, c := range enc {
+			left := 0
+			if i >= bpp {
+				left = int(row[i-bpp])
+			}
+			row[i] = c + byte((left+int(prev[i]))/2)
+		}
+	case 4: // Paeth
+		for i, c := range enc {
+			var a, cc int
+			if i >= bpp {
+				a = int(row[i-bpp])
+				cc = int(prev[i-bpp])
+			}
+			row[i] = c + byte(paeth(a, int(prev[i]), cc))
+		}
+	}
+	return nil
+}
+
+func paeth(a, b, c int) int {
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// decodeTIFF decodes the row enc, which was encoded with TIFF predictor 2:
+// each component is stored as the difference from the same
+// component of the pixel to its left.
+func (p *predictReader) decodeTIFF(enc []byte) {
+	row := p.row[:len(enc)]
+	copy(row, enc)
+	switch p.bpc {
+	case 8:
+		for i := p.colors; i < len(row); i++ {
+			row[i] += row[i-p.colors]
+		}
+	case 16:
+		for i := 2 * p.colors; i+1 < len(row); i += 2 {
+			x := uint16(row[i])<<8 | uint16(row[i+1])
+			y := uint16(row[i-2*p.colors])<<8 | uint16(row[i+1-2*p.colors])
+			x += y
+			row[i], row[i+1] = byte(x>>8), byte(x)
+		}
+	default:
+		// 1, 2, or 4 bits per component, packed high bits first.
+		bpc := uint(p.bpc)
+		mask := byte(1)<<bpc - 1
+		get := func(k int) byte {
+			shift := 8 - bpc - uint(k%(8/p.bpc))*bpc
+			return row[k/(8/p.bpc)] >> shift & mask
+		}
+		set := func(k int, x byte) {
+			shift := 8 - bpc - uint(k%(8/p.bpc))*bpc
+			i := k / (8 / p.bpc)
+			row[i] = row[i]&^(mask<<shift) | (x&mask)<<shift
+		}
+		n := len(row) * 8 / p.bpc
+		for k := p.colors; k < n; k++ {
+			set(k, get(k)+get(k-p.colors))
+		}
+	}
+}
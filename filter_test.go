@@ -0,0 +1,294 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdf
+
+import (
+	"bytes"
+	"compress/lzw"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// decode returns the result of applying the named filter
+// with decoding parameters param to data.
+func decode(name string, param dict, data []byte) ([]byte, error) {
+	rd, err := applyFilter(bytes.NewReader(data), name, Value{data: param}, 0)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(rd)
+}
+
+var filterTests = []struct {
+	name  string
+	param dict
+	in    string
+	out   string
+}{
+	// The example in PDF 32000-1:2008, §7.4.4.2.
+	{"LZWDecode", nil, "\x80\x0b\x60\x50\x22\x0c\x0c\x85\x01", "-----A---B"},
+	{"LZWDecode", dict{"EarlyChange": int64(1)}, "\x80\x0b\x60\x50\x22\x0c\x0c\x85\x01", "-----A---B"},
+
+	{"ASCII85Decode", nil, "<~BOu!rDZ~>", "hello"},
+	{"ASCII85Decode", nil, "BOu!r\nD Z~>", "hello"},
+	{"ASCII85Decode", nil, "<~z9jqo^~>", "\x00\x00\x00\x00Man "},
+	{"A85", nil, "<~BOu!r~>", "hell"},
+
+	{"ASCIIHexDecode", nil, "48656c6c6f>", "Hello"},
+	{"ASCIIHexDecode", nil, "48 65\n6C6c 6>", "Hell`"},
+	{"AHx", nil, "4", "@"},
+	{"AHx", nil, ">", ""},
+
+	{"RunLengthDecode", nil, "\x02abc\x80", "abc"},
+	{"RunLengthDecode", nil, "\xfex\x80", "xxx"},
+	{"RunLengthDecode", nil, "\x00a\xffb\x01cd\x80ignored", "abbcd"},
+	{"RL", nil, "\x81y", strings.Repeat("y", 128)},
+}
+
+func TestFilters(t *testing.T) {
+	for _, tt := range filterTests {
+		out, err := decode(tt.name, tt.param, []byte(tt.in))
+		if err != nil {
+			t.Errorf("%s %v %q: %v", tt.name, tt.param, tt.in, err)
+			continue
+		}
+		if string(out) != tt.out {
+			t.Errorf("%s %v %q = %q, want %q", tt.name, tt.param, tt.in, out, tt.out)
+		}
+	}
+}
+
+// lzwEncode encodes data using PDF's LZW encoding, with the given
+// EarlyChange parameter, starting over with a Clear code each time
+// the table fills.
+func lzwEncode(data []byte, early int) []byte {
+	var out []byte
+	var bits uint32
+	var nbits, width uint
+	put := func(code int) {
+		bits = bits<<width | uint32(code)
+		nbits += width
+		for nbits >= 8 {
+			out = append(out, byte(bits>>(nbits-8)))
+			nbits -= 8
+		}
+	}
+	var table map[string]int
+	clear := func() {
+		put(lzwClear)
+		width = 9
+		table = make(map[string]int)
+		for i := 0; i < 256; i++ {
+			table[string(rune(i))] = i
+		}
+	}
+
+	width = 9
+	clear()
+	next := 258
+	w := ""
+	for _, c := range data {
+		wc := w + string(rune(c))
+		if _, ok := table[wc]; ok {
+			w = wc
+			continue
+		}
+		put(table[w])
+		table[wc] = next
+		next++
+		// The decoder learns of each entry one code later, so
+		// it switches width one code after the encoder's table grows.
+		if next+early > 1<<width && width < 12 {
+			width++
+		}
+		if next == lzwMax-1 {
+			clear()
+			next = 258
+		}
+		w = string(rune(c))
+	}
+	if w != "" {
+		put(table[w])
+	}
+	put(lzwEOD)
+	if nbits > 0 {
+		out = append(out, byte(bits<<(8-nbits)))
+	}
+	return out
+}
+
+func TestLZWReset(t *testing.T) {
+	// Enough data from a small alphabet to fill the table several times.
+	rnd := rand.New(rand.NewSource(1))
+	data := make([]byte, 50000)
+	for i := range data {
+		data[i] = "abcdefgh"[rnd.Intn(8)]
+	}
+
+	// compress/lzw writes the EarlyChange 0 variant.
+	var buf bytes.Buffer
+	w := lzw.NewWriter(&buf, lzw.MSB, 8)
+	w.Write(data)
+	w.Close()
+
+	tests := []struct {
+		name  string
+		early int64
+		enc   []byte
+	}{
+		{"compress/lzw", 0, buf.Bytes()},
+		{"lzwEncode", 0, lzwEncode(data, 0)},
+		{"lzwEncode", 1, lzwEncode(data, 1)},
+	}
+	for _, tt := range tests {
+		out, err := decode("LZWDecode", dict{"EarlyChange": tt.early}, tt.enc)
+		if err != nil {
+			t.Errorf("%s EarlyChange %d: %v", tt.name, tt.early, err)
+			continue
+		}
+		if !bytes.Equal(out, data) {
+			t.Errorf("%s EarlyChange %d: decoded data differs", tt.name, tt.early)
+		}
+	}
+}
+
+// pngEncode encodes the rows of raw using PNG filter type types[i%len(types)]
+// for row i.
+func pngEncode(raw []byte, rowBytes, bpp int, types []byte) []byte {
+	var out []byte
+	prev := make([]byte, rowBytes)
+	for i := 0; i*rowBytes < len(raw); i++ {
+		row := raw[i*rowBytes : (i+1)*rowBytes]
+		typ := types[i%len(types)]
+		out = append(out, typ)
+		for j, x := range row {
+			var a, b, c int // left, up, upper left
+			b = int(prev[j])
+			if j >= bpp {
+				a, c = int(row[j-bpp]), int(prev[j-bpp])
+			}
+			var pred int
+			switch typ {
+			case 1:
+				pred = a
+			case 2:
+				pred = b
+			case 3:
+				pred = (a + b) / 2
+			case 4:
+				pred = c
+				if p := a + b - c; abs(p-a) <= abs(p-b) && abs(p-a) <= abs(p-c) {
+					pred = a
+				} else if abs(p-b) <= abs(p-c) {
+					pred = b
+				}
+			}
+			out = append(out, x-byte(pred))
+		}
+		prev = row
+	}
+	return out
+}
+
+// sample returns the k'th sample of bpc bits in row.
+func sample(row []byte, k, bpc int) int {
+	if bpc == 16 {
+		return int(row[2*k])<<8 | int(row[2*k+1])
+	}
+	bit := k * bpc
+	return int(row[bit/8]) >> uint(8-bpc-bit%8) & (1<<uint(bpc) - 1)
+}
+
+// setSample sets the k'th sample of bpc bits in row to x.
+func setSample(row []byte, k, bpc, x int) {
+	if bpc == 16 {
+		row[2*k], row[2*k+1] = byte(x>>8), byte(x)
+		return
+	}
+	bit := k * bpc
+	shift := uint(8 - bpc - bit%8)
+	mask := byte(1<<uint(bpc)-1) << shift
+	row[bit/8] = row[bit/8]&^mask | byte(x)<<shift&mask
+}
+
+// tiffEncode encodes the rows of raw using TIFF predictor 2.
+func tiffEncode(raw []byte, rowBytes, colors, bpc int) []byte {
+	out := append([]byte(nil), raw...)
+	n := rowBytes * 8 / bpc
+	for i := 0; i*rowBytes < len(out); i++ {
+		src := raw[i*rowBytes : (i+1)*rowBytes]
+		dst := out[i*rowBytes : (i+1)*rowBytes]
+		for k := colors; k < n; k++ {
+			setSample(dst, k, bpc, sample(src, k, bpc)-sample(src, k-colors, bpc))
+		}
+	}
+	return out
+}
+
+var predictTests = []struct {
+	pred    int64
+	colors  int
+	bpc     int
+	columns int
+	types   []byte // PNG filter types, cycled through by row
+}{
+	{10, 3, 8, 5, []byte{0}},
+	{11, 3, 8, 5, []byte{1}},
+	{12, 3, 8, 5, []byte{2}},
+	{13, 3, 8, 5, []byte{3}},
+	{14, 3, 8, 5, []byte{4}},
+	{15, 3, 8, 5, []byte{0, 1, 2, 3, 4}},
+	{10, 2, 16, 3, []byte{0}},
+	{11, 2, 16, 3, []byte{1}},
+	{12, 2, 16, 3, []byte{2}},
+	{13, 2, 16, 3, []byte{3}},
+	{14, 2, 16, 3, []byte{4}},
+	{15, 2, 16, 3, []byte{4, 3, 2, 1, 0}},
+	{15, 4, 2, 7, []byte{0, 1, 2, 3, 4}},
+
+	{2, 1, 1, 16, nil},
+	{2, 3, 1, 8, nil},
+	{2, 3, 2, 8, nil},
+	{2, 3, 4, 8, nil},
+	{2, 3, 8, 8, nil},
+	{2, 3, 16, 8, nil},
+	{2, 2, 4, 3, nil},
+}
+
+func TestPredictors(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, tt := range predictTests {
+		rowBytes := (tt.colors*tt.bpc*tt.columns + 7) / 8
+		raw := make([]byte, 6*rowBytes)
+		rnd.Read(raw)
+		var enc []byte
+		if tt.pred == 2 {
+			enc = tiffEncode(raw, rowBytes, tt.colors, tt.bpc)
+		} else {
+			enc = pngEncode(raw, rowBytes, (tt.colors*tt.bpc+7)/8, tt.types)
+		}
+		param := dict{
+			"Predictor":        tt.pred,
+			"Colors":           int64(tt.colors),
+			"BitsPerComponent": int64(tt.bpc),
+			"Columns":          int64(tt.columns),
+		}
+		rd, err := newPredictReader(bytes.NewReader(enc), Value{data: param}, 0)
+		if err != nil {
+			t.Errorf("%v: %v", param, err)
+			continue
+		}
+		out, err := ioutil.ReadAll(rd)
+		if err != nil {
+			t.Errorf("%v: %v", param, err)
+			continue
+		}
+		if !bytes.Equal(out, raw) {
+			t.Errorf("%v types %v:\nhave %x\nwant %x", param, tt.types, out, raw)
+		}
+	}
+}
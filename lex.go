@@ -32,6 +32,7 @@ type name string
 type keyword string
 
 // A buffer holds buffered input bytes from the PDF file.
+// Each buffer is used by a single goroutine, reading one object or stream.
 type buffer struct {
 	r           io.Reader // source of data
 	buf         []byte    // buffered data
@@ -44,8 +45,10 @@ type buffer struct {
 	allowStream bool
 	eof         bool
 	key         []byte
-	useAES      bool
+	crypt       cryptMethod
 	objptr      objptr
+	depth       int // nesting depth of arrays and dictionaries being read
+	maxDepth    int // limit on depth, or 0 for none
 }
 
 // newBuffer returns a new buffer reading from r at the given offset.
@@ -79,7 +82,7 @@ func (b *buffer) readByte() byte {
 }
 
 func (b *buffer) errorf(format string, args ...interface{}) {
-	panic(fmt.Errorf(format, args...))
+	panic(&Error{ID: b.objptr.id, Gen: b.objptr.gen, Offset: b.readOffset(), Err: fmt.Errorf(format, args...)})
 }
 
 func (b *buffer) reload() bool {
@@ -92,7 +95,7 @@ func (b *buffer) reload() bool {
 			b.eof = true
 			return false
 		}
-		b.errorf("malformed PDF: reading at offset %d: %v", b.offset, err)
+		b.errorf("malformed PDF: reading at offset %d: %w", b.offset, err)
 		return false
 	}
 	b.offset += int64(n)
@@ -415,17 +418,29 @@ func (b *buffer) readObject() object {
 		switch kw {
 		case "null":
 			return nil
-		case "<<":
-			return b.readDict()
-		case "[":
-			return b.readArray()
+		case "<<", "[":
+			b.depth++
+			if b.maxDepth > 0 && b.depth > b.maxDepth {
+				panic(&Error{ID: b.objptr.id, Gen: b.objptr.gen, Offset: b.readOffset(), Err: &LimitError{"MaxDepth", int64(b.maxDepth)}})
+			}
+			var x object
+			if kw == "<<" {
+				x = b.readDict()
+			} else {
+				x = b.readArray()
+			}
+			b.depth--
+			return x
 		}
 		b.errorf("unexpected keyword %q parsing object", kw)
 		return nil
 	}
 
 	if str, ok := tok.(string); ok && b.key != nil && b.objptr.id != 0 {
-		tok = decryptString(b.key, b.useAES, b.objptr, str)
+		var err error
+		if tok, err = decryptString(b.key, b.crypt, b.objptr, str); err != nil {
+			b.errorf("%v", err)
+		}
 	}
 
 	if !b.allowObjptr {
@@ -0,0 +1,235 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Limits on the resources used to read a file.
+
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Limits bounds the resources that a Reader uses, to protect
+// against malicious or badly damaged files.
+// A zero value for any field selects its default, which is no limit
+// unless stated otherwise, and a negative value means no limit.
+// Exceeding a limit is reported by an error wrapping a *LimitError.
+type Limits struct {
+	// MaxObjects is the maximum number of entries
+	// in the file's cross-reference table.
+	// The default is DefaultMaxObjects.
+	MaxObjects int
+
+	// MaxStreamSize is the maximum size of the decoded data
+	// of a single stream, in bytes. For a stream read using
+	// Value.PartialReader, it limits the partly decoded data.
+	// It has no default, because content streams and images
+	// can be large, and they are read incrementally.
+	MaxStreamSize int64
+
+	// MaxBufferedStreamSize is the maximum size of the decoded data
+	// of a stream that the Reader itself reads into memory:
+	// an object stream or a cross-reference stream.
+	// It applies in addition to MaxStreamSize.
+	// The default is DefaultMaxBufferedStreamSize.
+	MaxBufferedStreamSize int64
+
+	// MaxDepth is the maximum nesting depth of arrays and
+	// dictionaries within a single object or content stream.
+	// The default is DefaultMaxDepth.
+	MaxDepth int
+
+	// MaxResolves is the maximum number of indirect objects followed
+	// by a single traversal of the document's structure: the search for
+	// a page, for an inherited page attribute, or for an object in a chain
+	// of object streams, or the construction of the outline.
+	MaxResolves int
+
+	// MaxPageDepth is the maximum depth of the page tree.
+	MaxPageDepth int
+}
+
+// Defaults for the fields of Limits.
+const (
+	DefaultMaxObjects            = 8388607 // the implementation limit given by the PDF specification
+	DefaultMaxBufferedStreamSize = 256 << 20
+	DefaultMaxDepth              = 1000
+)
+
+// NoLimits turns off all the limits, including the default ones.
+// It should be used only for trusted files.
+var NoLimits = Limits{
+	MaxObjects:            -1,
+	MaxStreamSize:         -1,
+	MaxBufferedStreamSize: -1,
+	MaxDepth:              -1,
+	MaxResolves:           -1,
+	MaxPageDepth:          -1,
+}
+
+// effective returns the limits to enforce for l:
+// zero fields are replaced by their defaults,
+// and fields meaning no limit are set to zero.
+func (l Limits) effective() Limits {
+	if l.MaxObjects == 0 {
+		l.MaxObjects = DefaultMaxObjects
+	}
+	if l.MaxBufferedStreamSize == 0 {
+		l.MaxBufferedStreamSize = DefaultMaxBufferedStreamSize
+	}
+	if l.MaxDepth == 0 {
+		l.MaxDepth = DefaultMaxDepth
+	}
+	if l.MaxObjects < 0 {
+		l.MaxObjects = 0
+	}
+	if l.MaxStreamSize < 0 {
+		l.MaxStreamSize = 0
+	}
+	if l.MaxBufferedStreamSize < 0 {
+		l.MaxBufferedStreamSize = 0
+	}
+	if l.MaxDepth < 0 {
+		l.MaxDepth = 0
+	}
+	if l.MaxResolves < 0 {
+		l.MaxResolves = 0
+	}
+	if l.MaxPageDepth < 0 {
+		l.MaxPageDepth = 0
+	}
+	return l
+}
+
+// A LimitError reports that a file exceeds one of the Limits
+// configured for its Reader.
+type LimitError struct {
+	Limit string // name of the field in Limits, such as "MaxStreamSize"
+	Max   int64  // value of the limit
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("PDF exceeds limit %s=%d", e.Limit, e.Max)
+}
+
+// isLimit reports whether err is or wraps a *LimitError.
+func isLimit(err error) bool {
+	var e *LimitError
+	return errors.As(err, &e)
+}
+
+// checkObjects checks that a cross-reference table
+// with n entries is within r's limits.
+func (r *Reader) checkObjects(n int64) error {
+	if max := r.limits.MaxObjects; max > 0 && n > int64(max) {
+		return &LimitError{"MaxObjects", int64(max)}
+	}
+	return nil
+}
+
+// limitStream returns a reader for the decoded data of the stream x,
+// read from rd, that fails once the data exceeds Limits.MaxStreamSize.
+func (r *Reader) limitStream(x stream, rd io.Reader) io.Reader {
+	return limitData(x, rd, "MaxStreamSize", r.limits.MaxStreamSize)
+}
+
+// limitBuffered is like limitStream but for Limits.MaxBufferedStreamSize.
+func (r *Reader) limitBuffered(x stream, rd io.Reader) io.Reader {
+	return limitData(x, rd, "MaxBufferedStreamSize", r.limits.MaxBufferedStreamSize)
+}
+
+// limitData returns a reader for the decoded data of the stream x,
+// read from rd, that fails once the data exceeds max, the named limit.
+func limitData(x stream, rd io.Reader, limit string, max int64) io.Reader {
+	if max <= 0 {
+		return rd
+	}
+	return &limitReader{rd: rd, n: max, err: &Error{ID: x.ptr.id, Gen: x.ptr.gen, Offset: x.offset, Err: &LimitError{limit, max}}}
+}
+
+// A limitReader reads at most n more bytes from rd,
+// returning err if rd has more data than that.
+type limitReader struct {
+	rd  io.Reader
+	n   int64
+	err error
+}
+
+func (l *limitReader) Read(b []byte) (int, error) {
+	if l.n <= 0 {
+		var tmp [1]byte
+		if n, err := l.rd.Read(tmp[:]); n == 0 {
+			return 0, err
+		}
+		return 0, l.err
+	}
+	if int64(len(b)) > l.n {
+		b = b[:l.n]
+	}
+	n, err := l.rd.Read(b)
+	l.n -= int64(n)
+	return n, err
+}
+
+// A walk follows the indirect references met during one traversal
+// of the document's structure, to detect cycles and to enforce
+// Limits.MaxResolves.
+type walk struct {
+	r    *Reader
+	what string // what is being traversed, for errors
+	seen map[objptr]bool
+}
+
+func (r *Reader) newWalk(what string) *walk {
+	return &walk{r: r, what: what, seen: make(map[objptr]bool)}
+}
+
+// visit records that the traversal follows x, if x is an indirect reference.
+func (w *walk) visit(x object) error {
+	ptr, ok := x.(objptr)
+	if !ok {
+		return nil
+	}
+	if w.seen[ptr] {
+		return &Error{ID: ptr.id, Gen: ptr.gen, Offset: -1, Err: fmt.Errorf("malformed PDF: cycle in %s", w.what)}
+	}
+	if max := w.r.limits.MaxResolves; max > 0 && len(w.seen) >= max {
+		return &Error{ID: ptr.id, Gen: ptr.gen, Offset: -1, Err: &LimitError{"MaxResolves", int64(max)}}
+	}
+	w.seen[ptr] = true
+	return nil
+}
+
+// key returns v.Key(key), recording the reference followed, if any.
+func (w *walk) key(v Value, key string) (Value, error) {
+	var x object
+	switch d := v.data.(type) {
+	case dict:
+		x = d[name(key)]
+	case stream:
+		x = d.hdr[name(key)]
+	}
+	if err := w.visit(x); err != nil {
+		return Value{}, err
+	}
+	return v.Key(key), nil
+}
+
+// index returns v.Index(i), recording the reference followed, if any.
+func (w *walk) index(v Value, i int) (Value, error) {
+	if a, ok := v.data.(array); ok && 0 <= i && i < len(a) {
+		if err := w.visit(a[i]); err != nil {
+			return Value{}, err
+		}
+	}
+	return v.Index(i), nil
+}
+
+// fail reports err and returns a null Value recording it.
+func (r *Reader) fail(err error) Value {
+	r.report(err)
+	return Value{r, objptr{}, nil, err, false}
+}
@@ -0,0 +1,61 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMaxBufferedStreamSize(t *testing.T) {
+	f := newTestFile()
+	o1 := f.obj(1, testCatalog)
+	o2 := f.obj(2, "<< /Type /Pages /Kids [] /Count 0 >>")
+	o4 := f.objStm(4, []int{3}, []string{"(" + strings.Repeat("x", 1000) + ")"})
+	start := int64(f.Len())
+	f.xrefStream(5, []xentry{
+		{1, 1, o1, 0},
+		{2, 1, o2, 0},
+		{3, 2, 4, 0},
+		{4, 1, o4, 0},
+		{5, 1, start, 0},
+	}, " /Size 6 /Root 1 0 R")
+	data := f.end(start)
+
+	for _, max := range []int64{0, 2000, -1, 500} {
+		r := openTest(t, data, &ReaderOptions{Limits: Limits{MaxBufferedStreamSize: max}})
+		v := r.Resolve(3, 0)
+		if max == 500 {
+			if !isLimit(v.Err()) {
+				t.Errorf("MaxBufferedStreamSize=%d: err = %v, want limit error", max, v.Err())
+			}
+			continue
+		}
+		if v.Err() != nil || len(v.RawString()) != 1000 {
+			t.Errorf("MaxBufferedStreamSize=%d: object 3 has length %d (err %v), want 1000", max, len(v.RawString()), v.Err())
+		}
+	}
+}
+
+func TestMaxBufferedStreamSizeXref(t *testing.T) {
+	f := newTestFile()
+	o1 := f.obj(1, testCatalog)
+	o2 := f.obj(2, "<< /Type /Pages /Kids [] /Count 0 >>")
+	start := int64(f.Len())
+	f.xrefStream(3, []xentry{
+		{1, 1, o1, 0},
+		{2, 1, o2, 0},
+		{3, 1, start, 0},
+	}, " /Size 4 /Root 1 0 R")
+	data := f.end(start)
+
+	// The stream holds 3 entries of 7 bytes.
+	openTest(t, data, &ReaderOptions{Strict: true, Limits: Limits{MaxBufferedStreamSize: 21}})
+	_, err := NewReaderOptions(bytes.NewReader(data), int64(len(data)), &ReaderOptions{Strict: true, Limits: Limits{MaxBufferedStreamSize: 20}})
+	if !isLimit(err) {
+		t.Errorf("MaxBufferedStreamSize=20: err = %v, want limit error", err)
+	}
+}
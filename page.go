@@ -5,8 +5,11 @@
 package pdf
 
 import (
+	"context"
 	"fmt"
+	"runtime"
 	"strings"
+	"sync"
 )
 
 // A Page represent a single page in a PDF file.
@@ -18,11 +21,20 @@ type Page struct {
 // Page returns the page for the given page number.
 // Page numbers are indexed starting at 1, not 0.
 // If the page is not found, Page returns a Page with p.V.IsNull().
+// If the page tree is cyclic or exceeds the Reader's limits,
+// p.V.Err() reports the problem.
 func (r *Reader) Page(num int) Page {
 	num-- // now 0-indexed
-	page := r.Trailer().Key("Root").Key("Pages")
+	w := r.newWalk("page tree")
+	page, err := w.key(r.Trailer().Key("Root"), "Pages")
+	depth := 0
 Search:
-	for page.Key("Type").Name() == "Pages" {
+	for err == nil && page.Key("Type").Name() == "Pages" {
+		if max := r.limits.MaxPageDepth; max > 0 && depth >= max {
+			err = &Error{ID: page.ptr.id, Gen: page.ptr.gen, Offset: -1, Err: &LimitError{"MaxPageDepth", int64(max)}}
+			break
+		}
+		depth++
 		count := int(page.Key("Count").Int64())
 		if count < num {
 			return Page{}
@@ -33,7 +45,9 @@ Search:
 			if kid.Key("Type").Name() == "Pages" {
 				c := int(kid.Key("Count").Int64())
 				if num < c {
-					page = kid
+					if page, err = w.index(kids, i); err != nil {
+						break Search
+					}
 					continue Search
 				}
 				num -= c
@@ -46,6 +60,10 @@ Search:
 				num--
 			}
 		}
+		break // not found among kids
+	}
+	if err != nil {
+		return Page{r.fail(err)}
 	}
 	return Page{}
 }
@@ -55,11 +73,89 @@ func (r *Reader) NumPage() int {
 	return int(r.Trailer().Key("Root").Key("Pages").Key("Count").Int64())
 }
 
+// ForEachPage calls fn for each page in the file, with the page's number,
+// counting from 1. It uses up to workers goroutines, or runtime.GOMAXPROCS(0)
+// if workers ≤ 0, so the calls may run in parallel and in any order.
+// Once fn returns an error, a page cannot be found, or ctx is cancelled,
+// ForEachPage starts no more calls, waits for the calls in progress,
+// and returns that error or ctx.Err().
+func (r *Reader) ForEachPage(ctx context.Context, workers int, fn func(n int, p Page) error) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	npage := r.NumPage()
+	var (
+		mu       sync.Mutex
+		next     = 1
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+	for i := 0; i < workers && i < npage; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				mu.Lock()
+				n := next
+				next++
+				mu.Unlock()
+				if n > npage {
+					return
+				}
+				p := r.Page(n)
+				if p.V.IsNull() {
+					err := p.V.Err()
+					if err == nil {
+						err = fmt.Errorf("malformed PDF: page %d not found", n)
+					}
+					fail(err)
+					return
+				}
+				if err := fn(n, p); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
 func (p Page) findInherited(key string) Value {
-	for v := p.V; !v.IsNull(); v = v.Key("Parent") {
-		if r := v.Key(key); !r.IsNull() {
-			return r
+	if p.V.r == nil {
+		return Value{}
+	}
+	r := p.V.r
+	w := r.newWalk("page tree")
+	depth := 0
+	for v := p.V; !v.IsNull(); {
+		if x := v.Key(key); !x.IsNull() {
+			return x
+		}
+		var err error
+		if v, err = w.key(v, "Parent"); err != nil {
+			return r.fail(err)
+		}
+		// depth counts the ancestors already searched, as in Page.
+		if max := r.limits.MaxPageDepth; max > 0 && depth >= max && !v.IsNull() {
+			return r.fail(&Error{ID: v.ptr.id, Gen: v.ptr.gen, Offset: -1, Err: &LimitError{"MaxPageDepth", int64(max)}})
 		}
+		depth++
 	}
 	return Value{}
 }
@@ -145,7 +241,7 @@ func (f Font) Encoder() TextEncoding {
 			// TODO: Should be big-endian UCS-2 decoder
 			return &nopEncoder{}
 		default:
-			println("unknown encoding", enc.Name())
+			f.V.warnf("font", "unknown encoding %s", enc.Name())
 			return &nopEncoder{}
 		}
 	case Dict:
@@ -153,7 +249,7 @@ func (f Font) Encoder() TextEncoding {
 	case Null:
 		// ok, try ToUnicode
 	default:
-		println("unexpected encoding", enc.String())
+		f.V.warnf("font", "unexpected encoding %v", enc)
 		return &nopEncoder{}
 	}
 
@@ -228,6 +324,7 @@ func (e *byteEncoder) Decode(raw string) (text string) {
 }
 
 type cmap struct {
+	v       Value // the CMap stream, for warnings
 	space   [4][][2]string
 	bfrange []bfrange
 }
@@ -254,21 +351,21 @@ Parse:
 								continue Parse
 							}
 							if bf.dst.Kind() == Array {
-								fmt.Printf("array %v\n", bf.dst)
+								m.v.warnf("cmap", "unsupported bfrange destination %v", bf.dst)
 							} else {
-								fmt.Printf("unknown dst %v\n", bf.dst)
+								m.v.warnf("cmap", "unknown bfrange destination %v", bf.dst)
 							}
 							r = append(r, noRune)
 							continue Parse
 						}
 					}
-					fmt.Printf("no text for %q", text)
+					m.v.warnf("cmap", "no text for %q", text)
 					r = append(r, noRune)
 					continue Parse
 				}
 			}
 		}
-		println("no code space found")
+		m.v.warnf("cmap", "no code space for %q", raw[:1])
 		r = append(r, noRune)
 		raw = raw[1:]
 	}
@@ -281,9 +378,16 @@ type bfrange struct {
 	dst Value
 }
 
-func readCmap(toUnicode Value) *cmap {
+func readCmap(toUnicode Value) (cm *cmap) {
+	defer func() {
+		if e := recover(); e != nil {
+			toUnicode.r.report(toError(e, toUnicode.ptr, -1))
+			cm = nil
+		}
+	}()
+
 	n := -1
-	var m cmap
+	m := cmap{v: toUnicode}
 	ok := true
 	Interpret(toUnicode, func(stk *Stack, op string) {
 		if !ok {
@@ -291,9 +395,8 @@ func readCmap(toUnicode Value) *cmap {
 		}
 		switch op {
 		case "findresource":
-			category := stk.Pop()
-			key := stk.Pop()
-			fmt.Println("findresource", key, category)
+			stk.Pop() // key
+			stk.Pop() // category
 			stk.Push(newDict())
 		case "begincmap":
 			stk.Push(newDict())
@@ -303,14 +406,14 @@ func readCmap(toUnicode Value) *cmap {
 			n = int(stk.Pop().Int64())
 		case "endcodespacerange":
 			if n < 0 {
-				println("missing begincodespacerange")
+				toUnicode.warnf("cmap", "missing begincodespacerange")
 				ok = false
 				return
 			}
 			for i := 0; i < n; i++ {
 				hi, lo := stk.Pop().RawString(), stk.Pop().RawString()
 				if len(lo) == 0 || len(lo) != len(hi) {
-					println("bad codespace range")
+					toUnicode.warnf("cmap", "bad codespace range")
 					ok = false
 					return
 				}
@@ -328,13 +431,12 @@ func readCmap(toUnicode Value) *cmap {
 				m.bfrange = append(m.bfrange, bfrange{srcLo, srcHi, dst})
 			}
 		case "defineresource":
-			category := stk.Pop().Name()
+			stk.Pop() // category
 			value := stk.Pop()
-			key := stk.Pop().Name()
-			fmt.Println("defineresource", key, value, category)
+			stk.Pop() // key
 			stk.Push(value)
 		default:
-			println("interp\t", op)
+			toUnicode.warnf("cmap", "ignoring operator %s", op)
 		}
 	})
 	if !ok {
@@ -402,8 +504,35 @@ type gstate struct {
 }
 
 // Content returns the page's content.
+// If the content stream is malformed, Content returns the content
+// found before the problem, which is passed to the Reader's error handler.
 func (p Page) Content() Content {
+	c, err := p.ContentErr()
+	if err != nil {
+		p.V.r.report(err)
+	}
+	return c
+}
+
+// ContentErr is like Content but also returns the error, if any,
+// that stopped the interpretation of the page's content stream.
+// Even when the error is non-nil, the returned Content holds
+// the text and rectangles found before the problem.
+func (p Page) ContentErr() (c Content, err error) {
+	return p.ContentContext(context.Background())
+}
+
+// ContentContext is like ContentErr but stops interpreting the content
+// stream once ctx is cancelled, returning the partial Content and ctx.Err().
+func (p Page) ContentContext(ctx context.Context) (c Content, err error) {
 	strm := p.V.Key("Contents")
+	switch strm.Kind() {
+	case Null:
+		// A page without content is blank.
+		return Content{}, strm.Err()
+	case Array:
+		return Content{}, &Error{ID: p.V.ptr.id, Gen: p.V.ptr.gen, Offset: -1, Err: fmt.Errorf("unsupported PDF: array of content streams")}
+	}
 	var enc TextEncoding = &nopEncoder{}
 
 	var g = gstate{
@@ -436,7 +565,16 @@ func (p Page) Content() Content {
 
 	var rect []Rect
 	var gstack []gstate
-	Interpret(strm, func(stk *Stack, op string) {
+	defer func() {
+		c = Content{text, rect}
+	}()
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+	defer catch(&err, strm.ptr, -1)
+	InterpretContext(ctx, strm, func(stk *Stack, op string) {
 		n := stk.Len()
 		args := make([]Value, n)
 		for i := n - 1; i >= 0; i-- {
@@ -529,7 +667,7 @@ func (p Page) Content() Content {
 			g.Tf = p.Font(f)
 			enc = g.Tf.Encoder()
 			if enc == nil {
-				println("no cmap for", f)
+				g.Tf.V.warnf("font", "no encoding for font %s", f)
 				enc = &nopEncoder{}
 			}
 			g.Tfs = args[1].Float64()
@@ -611,7 +749,7 @@ func (p Page) Content() Content {
 			g.Th = args[0].Float64() / 100
 		}
 	})
-	return Content{text, rect}
+	return Content{text, rect}, nil
 }
 
 // TextVertical implements sort.Interface for sorting
@@ -652,15 +790,34 @@ type Outline struct {
 // Outline returns the document outline.
 // The Outline returned is the root of the outline tree and typically has no Title itself.
 // That is, the children of the returned root are the top-level entries in the outline.
+// If the outline is cyclic or exceeds the Reader's limits,
+// Outline reports the problem to the error handler and returns
+// the part of the outline read before it.
 func (r *Reader) Outline() Outline {
-	return buildOutline(r.Trailer().Key("Root").Key("Outlines"))
+	w := r.newWalk("outline")
+	root, err := w.key(r.Trailer().Key("Root"), "Outlines")
+	if err != nil {
+		r.fail(err)
+		return Outline{}
+	}
+	x, err := buildOutline(w, root)
+	if err != nil {
+		r.fail(err)
+	}
+	return x
 }
 
-func buildOutline(entry Value) Outline {
+func buildOutline(w *walk, entry Value) (Outline, error) {
 	var x Outline
 	x.Title = entry.Key("Title").Text()
-	for child := entry.Key("First"); child.Kind() == Dict; child = child.Key("Next") {
-		x.Child = append(x.Child, buildOutline(child))
+	child, err := w.key(entry, "First")
+	for err == nil && child.Kind() == Dict {
+		var c Outline
+		c, err = buildOutline(w, child)
+		x.Child = append(x.Child, c)
+		if err == nil {
+			child, err = w.key(child, "Next")
+		}
 	}
-	return x
+	return x, err
 }
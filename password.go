@@ -0,0 +1,168 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Encoding of passwords for the standard security handler.
+
+package pdf
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+// passwordBytes returns the byte strings to try as the encoded form of
+// password for revision R of the standard security handler.
+// Revisions 2 through 4 expect the password in PDFDocEncoding, which
+// agrees with Latin-1 for most letters. Revisions 5 and 6 expect it
+// in UTF-8, prepared with SASLprep and truncated to 127 bytes.
+// Some writers skip the encoding step, so if the encoded password
+// differs from its UTF-8 bytes, those are tried too.
+func passwordBytes(R int, password string) [][]byte {
+	raw := []byte(password)
+	var enc []byte
+	var ok bool
+	if R >= 5 {
+		var s string
+		s, ok = saslPrep(password)
+		enc = []byte(s)
+		if len(enc) > 127 {
+			enc = enc[:127]
+		}
+		if len(raw) > 127 {
+			raw = raw[:127]
+		}
+	} else {
+		enc, ok = pdfDocEncode(password)
+	}
+	if !ok || bytes.Equal(enc, raw) {
+		return [][]byte{raw}
+	}
+	return [][]byte{enc, raw}
+}
+
+// saslPrep prepares s using SASLprep (RFC 4013), reporting whether s
+// is acceptable. Full NFKC normalization needs tables that this package
+// does not carry, so the normalization step is limited to composing
+// Latin letters with combining marks and replacing the compatibility
+// characters common in Latin text. The check for mixed-direction
+// text is also omitted.
+func saslPrep(s string) (string, bool) {
+	if !utf8.ValidString(s) {
+		return "", false
+	}
+	var out []rune
+	add := func(r rune) {
+		if n := len(out); n > 0 {
+			if c, ok := composeLatin(out[n-1], r); ok {
+				out[n-1] = c
+				return
+			}
+		}
+		out = append(out, r)
+	}
+	for _, r := range s {
+		switch {
+		case mappedToNothing(r):
+			continue
+		case r == 0x200B || r != ' ' && unicode.Is(unicode.Zs, r):
+			r = ' '
+		case 0xFF01 <= r && r <= 0xFF5E:
+			r -= 0xFF01 - '!' // fullwidth ASCII
+		}
+		if x, ok := compatLatin[r]; ok {
+			for _, r := range x {
+				add(r)
+			}
+			continue
+		}
+		add(r)
+	}
+	for _, r := range out {
+		if prohibited(r) {
+			return "", false
+		}
+	}
+	return string(out), true
+}
+
+// mappedToNothing reports whether r is one of the characters
+// that SASLprep deletes (RFC 3454, Table B.1).
+func mappedToNothing(r rune) bool {
+	switch r {
+	case 0x00AD, 0x034F, 0x1806, 0x180B, 0x180C, 0x180D, 0x200C, 0x200D, 0x2060, 0xFEFF:
+		return true
+	}
+	return 0xFE00 <= r && r <= 0xFE0F
+}
+
+// prohibited reports whether r may not appear in a string
+// prepared with SASLprep (RFC 4013, §2.3).
+func prohibited(r rune) bool {
+	switch {
+	case unicode.IsControl(r), unicode.Is(unicode.Co, r), unicode.Is(unicode.Cs, r):
+		return true
+	case r&0xFFFE == 0xFFFE, 0xFDD0 <= r && r <= 0xFDEF: // non-characters
+		return true
+	case 0xFFF9 <= r && r <= 0xFFFD, 0x2FF0 <= r && r <= 0x2FFB:
+		return true
+	case r == 0x0340, r == 0x0341, r == 0x200E, r == 0x200F,
+		0x202A <= r && r <= 0x202E, 0x206A <= r && r <= 0x206F: // directional controls
+		return true
+	case r == 0x06DD, r == 0x070F, r == 0x180E, r == 0x2028, r == 0x2029,
+		0x2060 <= r && r <= 0x2063, 0x1D173 <= r && r <= 0x1D17A:
+		return true
+	case r == 0xE0001, 0xE0020 <= r && r <= 0xE007F: // tags
+		return true
+	}
+	return false
+}
+
+// composeLatin returns the precomposed form of the letter r
+// followed by the combining mark m, if there is one.
+func composeLatin(r, m rune) (rune, bool) {
+	x, ok := latinCompose[m]
+	if !ok {
+		return 0, false
+	}
+	for i, b := range []rune(x[0]) {
+		if b == r {
+			return []rune(x[1])[i], true
+		}
+	}
+	return 0, false
+}
+
+// latinCompose lists, for each combining mark, the letters it composes with
+// and the precomposed letters that result, in the blocks Latin-1 Supplement,
+// Latin Extended-A and Latin Extended-B.
+var latinCompose = map[rune][2]string{
+	0x0300: {"AEIOUaeiouÜüNn", "ÀÈÌÒÙàèìòùǛǜǸǹ"},                                               // grave accent
+	0x0301: {"AEIOUYaeiouyCcLlNnRrSsZzÜüGgÅåÆæØø", "ÁÉÍÓÚÝáéíóúýĆćĹĺŃńŔŕŚśŹźǗǘǴǵǺǻǼǽǾǿ"},       // acute accent
+	0x0302: {"AEIOUaeiouCcGgHhJjSsWwYy", "ÂÊÎÔÛâêîôûĈĉĜĝĤĥĴĵŜŝŴŵŶŷ"},                           // circumflex accent
+	0x0303: {"ANOanoIiUu", "ÃÑÕãñõĨĩŨũ"},                                                       // tilde
+	0x0304: {"AaEeIiOoUuÜüÄäȦȧÆæǪǫÖöÕõȮȯYy", "ĀāĒēĪīŌōŪūǕǖǞǟǠǡǢǣǬǭȪȫȬȭȰȱȲȳ"},                   // macron
+	0x0306: {"AaEeGgIiOoUu", "ĂăĔĕĞğĬĭŎŏŬŭ"},                                                   // breve
+	0x0307: {"CcEeGgIZzAaOo", "ĊċĖėĠġİŻżȦȧȮȯ"},                                                 // dot above
+	0x0308: {"AEIOUaeiouyY", "ÄËÏÖÜäëïöüÿŸ"},                                                   // diaeresis
+	0x030A: {"AaUu", "ÅåŮů"},                                                                   // ring above
+	0x030B: {"OoUu", "ŐőŰű"},                                                                   // double acute accent
+	0x030C: {"CcDdEeLlNnRrSsTtZzAaIiOoUuÜüGgKkƷʒjHh", "ČčĎďĚěĽľŇňŘřŠšŤťŽžǍǎǏǐǑǒǓǔǙǚǦǧǨǩǮǯǰȞȟ"}, // caron
+	0x030F: {"AaEeIiOoRrUu", "ȀȁȄȅȈȉȌȍȐȑȔȕ"},                                                   // double grave accent
+	0x0311: {"AaEeIiOoRrUu", "ȂȃȆȇȊȋȎȏȒȓȖȗ"},                                                   // inverted breve
+	0x031B: {"OoUu", "ƠơƯư"},                                                                   // horn
+	0x0326: {"SsTt", "ȘșȚț"},                                                                   // comma below
+	0x0327: {"CcGgKkLlNnRrSsTtEe", "ÇçĢģĶķĻļŅņŖŗŞşŢţȨȩ"},                                       // cedilla
+	0x0328: {"AaEeIiUuOo", "ĄąĘęĮįŲųǪǫ"},                                                       // ogonek
+}
+
+// compatLatin gives the NFKC replacements for the compatibility
+// characters in the Latin blocks and the Latin ligatures.
+var compatLatin = map[rune]string{
+	'ª': "a", '²': "2", '³': "3", 'µ': "μ", '¹': "1", 'º': "o",
+	'¼': "1⁄4", '½': "1⁄2", '¾': "3⁄4",
+	'¨': " \u0308", '¯': " \u0304", '´': " \u0301", '¸': " \u0327",
+	'Ĳ': "IJ", 'ĳ': "ij", 'Ŀ': "L·", 'ŀ': "l·", 'ŉ': "ʼn", 'ſ': "s",
+	'ﬀ': "ff", 'ﬁ': "fi", 'ﬂ': "fl", 'ﬃ': "ffi", 'ﬄ': "ffl", 'ﬅ': "st", 'ﬆ': "st",
+}
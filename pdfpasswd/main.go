@@ -2,26 +2,76 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Pdfpasswd searches for the password for an encrypted PDF
-// by trying all strings over a given alphabet up to a given length.
+// Pdfpasswd searches for the password for an encrypted PDF.
+// By default it tries all strings over a given alphabet up to a given length.
+// With -w, it tries the words in a wordlist instead, changed by the
+// rules given with -r. With -mask, it tries the strings matching a mask
+// such as ?u?l?l?l?d?d, in which ?l, ?u, ?d and ?s stand for a lower case
+// letter, upper case letter, digit and symbol, ?a for any of those,
+// ?h and ?H for a hexadecimal digit, ?1 through ?4 for the custom
+// character sets given with -1 through -4, and ?? for a question mark.
+//
+// With -owner, it searches for the owner password instead of the user
+// password, for files that open without a password but restrict what
+// may be done with them. For files encrypted with 40-bit keys, -key40
+// searches all the possible file keys instead of passwords, which always
+// succeeds, given time. The key cannot be turned back into a password,
+// but a program can decrypt the file with it, by passing it to
+// pdf.NewReaderOptions as ReaderOptions.FileKey.
+//
+// The search runs on several goroutines and reports its progress
+// periodically. With -c, it records its progress in a checkpoint file,
+// from which an interrupted search resumes when run again
+// with the same file and options.
+//
+// The file may be a PDF or a text file holding the hash line that
+// pdfpasswd -export prints for a PDF, so that a search need not
+// read the PDF again.
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"runtime"
+	"time"
 
 	"rsc.io/pdf"
 )
 
 var (
 	alphabet  = flag.String("a", "0123456789", "alphabet")
+	minLength = flag.Int("min", 1, "min length")
 	maxLength = flag.Int("m", 4, "max length")
+	wordlist  = flag.String("w", "", "try words from wordlist `file` (- for standard input)")
+	rules     = flag.String("r", "", "comma-separated `rules` to apply to words")
+	mask      = flag.String("mask", "", "try strings matching `mask`")
+	charsets  [4]*string
+	workers   = flag.Int("j", runtime.NumCPU(), "number of concurrent workers")
+	ckpt      = flag.String("c", "", "checkpoint file")
+	progress  = flag.Duration("progress", 10*time.Second, "interval between progress reports (0 for none)")
+	owner     = flag.Bool("owner", false, "search for the owner password")
+	key40     = flag.Bool("key40", false, "search all 40-bit file keys")
+	export    = flag.Bool("export", false, "print the password hash line and exit")
 )
 
+func init() {
+	for i := range charsets {
+		charsets[i] = flag.String(fmt.Sprint(i+1), "", fmt.Sprintf("custom `charset` ?%d for -mask", i+1))
+	}
+}
+
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: pdfpasswd [-a alphabet] [-m maxlength] file\n")
+	fmt.Fprintf(os.Stderr, "usage: pdfpasswd [-a alphabet] [-min minlength] [-m maxlength] [options] file\n")
+	fmt.Fprintf(os.Stderr, "       pdfpasswd -w wordlist [-r rules] [options] file\n")
+	fmt.Fprintf(os.Stderr, "       pdfpasswd -mask mask [-1 charset] ... [options] file\n")
+	fmt.Fprintf(os.Stderr, "       pdfpasswd -key40 [options] file\n")
+	fmt.Fprintf(os.Stderr, "       pdfpasswd -export file.pdf\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "rules (or all):\n%s", ruleUsage())
 	os.Exit(2)
 }
 
@@ -35,76 +85,96 @@ func main() {
 		usage()
 	}
 
-	f, err := os.Open(flag.Arg(0))
+	params, err := readParams(flag.Arg(0))
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *export {
+		fmt.Println(params)
+		return
+	}
 
-	last := ""
-	alpha := *alphabet
-	ctr := make([]int, *maxLength)
-	pw := func() string {
-		inc(ctr, len(alpha)+1)
-		for !valid(ctr) {
-			inc(ctr, len(alpha)+1)
-		}
-		if done(ctr) {
-			return ""
+	if *workers < 1 {
+		*workers = 1
+	}
+	kind := "user password"
+	check := params.CheckUserPassword
+	if *owner {
+		kind, check = "owner password", params.CheckOwnerPassword
+	} else if !*key40 && check("") {
+		fmt.Printf("user password: %q\n", "")
+		log.Print("the file opens without a password; use -owner to search for the owner password")
+		return
+	}
+
+	var space keyspace
+	switch {
+	case *wordlist != "" && *mask != "" || *key40 && (*wordlist != "" || *mask != ""):
+		log.Fatal("can use only one of -w, -mask and -key40")
+	case *key40:
+		if params.R >= 5 || params.R > 2 && params.Length != 40 {
+			log.Fatalf("file uses %d-bit keys, not 40-bit keys", params.Length)
 		}
-		buf := make([]byte, len(ctr))
-		var i int
-		for i = 0; i < len(buf); i++ {
-			if ctr[i] == 0 {
-				break
-			}
-			buf[i] = alpha[ctr[i]-1]
+		space = keySpace{}
+		kind = "file key"
+		check = func(key string) bool { return params.CheckKey([]byte(key)) }
+	case *wordlist != "":
+		space, err = newWordSpace(*wordlist, *rules)
+	case *mask != "":
+		var custom []string
+		for _, c := range charsets {
+			custom = append(custom, *c)
 		}
-		last = string(buf[:i])
-		println(last)
-		return last
+		space, err = newMaskSpace(*mask, custom)
+	default:
+		space, err = newBruteSpace(*alphabet, *minLength, *maxLength)
 	}
-	st, err := f.Stat()
 	if err != nil {
 		log.Fatal(err)
 	}
-	_, err = pdf.NewReaderEncrypted(f, st.Size(), pw)
-	if err != nil {
-		if err == pdf.ErrInvalidPassword {
-			log.Fatal("password not found")
+	// The checkpoint applies only to the same search of the same file.
+	target := kind + " " + params.String()
+	var start uint64
+	if *ckpt != "" {
+		start, err = loadCheckpoint(*ckpt, target, space)
+		if err != nil {
+			log.Fatal(err)
 		}
-		log.Fatal("reading pdf: %v", err)
-	}
-	fmt.Printf("password: %q\n", last)
-}
-
-func inc(ctr []int, n int) {
-	for i := 0; i < len(ctr); i++ {
-		ctr[i]++
-		if ctr[i] < n {
-			break
+		if start > 0 {
+			log.Printf("resuming at candidate %d of %d", start, space.Len())
 		}
-		ctr[i] = 0
 	}
-}
-
-func done(ctr []int) bool {
-	for _, x := range ctr {
-		if x != 0 {
-			return false
+	s := &search{
+		target:     target,
+		space:      space,
+		check:      check,
+		workers:    *workers,
+		checkpoint: *ckpt,
+		progress:   *progress,
+	}
+	pw, ok := s.run(start)
+	if !ok {
+		if s.low < space.Len() {
+			os.Exit(1)
 		}
+		log.Fatal("password not found")
 	}
-	return true
+	fmt.Printf("%s: %s\n", kind, show(space, []byte(pw)))
 }
 
-func valid(ctr []int) bool {
-	i := len(ctr)
-	for i > 0 && ctr[i-1] == 0 {
-		i--
+// readParams returns the encryption parameters for file,
+// which is either a PDF or a hash line printed by -export.
+func readParams(file string) (*pdf.EncryptionParams, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
 	}
-	for i--; i >= 0; i-- {
-		if ctr[i] == 0 {
-			return false
-		}
+	if bytes.HasPrefix(data, []byte("$pdf$")) {
+		return pdf.ParseEncryptionParams(string(data))
+	}
+	params, err := pdf.ReadEncryptionParams(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("reading pdf: %v", err)
 	}
-	return true
+	return params, nil
 }
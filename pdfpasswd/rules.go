@@ -0,0 +1,143 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A rule turns a word from a wordlist into a candidate password,
+// by changing its case, substituting look-alike characters,
+// and appending a suffix, in that order.
+type rule struct {
+	cas    caseMode
+	leet   bool
+	suffix string
+}
+
+type caseMode int
+
+const (
+	asIs       caseMode = iota // as given
+	lower                      // word
+	capitalize                 // Word
+	upper                      // WORD
+)
+
+// leet gives the leetspeak substitutions.
+var leet = strings.NewReplacer("a", "@", "A", "@", "e", "3", "E", "3", "i", "1", "I", "1",
+	"o", "0", "O", "0", "s", "$", "S", "$", "t", "7", "T", "7")
+
+// apply appends to buf the result of applying r to word.
+func (r rule) apply(buf []byte, word string) []byte {
+	switch r.cas {
+	case lower:
+		word = strings.ToLower(word)
+	case capitalize:
+		if c, n := utf8.DecodeRuneInString(word); n > 0 {
+			word = string(unicode.ToUpper(c)) + strings.ToLower(word[n:])
+		}
+	case upper:
+		word = strings.ToUpper(word)
+	}
+	if r.leet {
+		word = leet.Replace(word)
+	}
+	buf = append(buf, word...)
+	return append(buf, r.suffix...)
+}
+
+// ruleNames lists the rule sets accepted by parseRules.
+var ruleNames = map[string]string{
+	"case":    "also try the word in lower case, capitalized, and in upper case",
+	"leet":    "also try the word with leetspeak substitutions (p@$$w0rd)",
+	"digits":  "also try the word followed by one or two digits",
+	"years":   "also try the word followed by a year from 1950 to 2030",
+	"symbols": "also try the word followed by one of " + symbols,
+}
+
+const symbols = "!@#$%&*?."
+
+// parseRules returns the rules selected by spec, a comma-separated list
+// of rule set names, or "all". The rules try every combination of the
+// selected case changes, substitutions and suffixes; the empty spec gives
+// a single rule that uses the word as is.
+func parseRules(spec string) ([]rule, error) {
+	on := make(map[string]bool)
+	if spec == "all" {
+		for name := range ruleNames {
+			on[name] = true
+		}
+	} else if spec != "" {
+		for _, name := range strings.Split(spec, ",") {
+			if ruleNames[name] == "" {
+				return nil, fmt.Errorf("unknown rule %q", name)
+			}
+			on[name] = true
+		}
+	}
+
+	cases := []caseMode{asIs}
+	if on["case"] {
+		cases = append(cases, lower, capitalize, upper)
+	}
+	leets := []bool{false}
+	if on["leet"] {
+		leets = append(leets, true)
+	}
+	// Digits and years are alternatives; symbols may follow either.
+	suffixes := []string{""}
+	if on["digits"] {
+		for i := 0; i < 10; i++ {
+			suffixes = append(suffixes, fmt.Sprint(i))
+		}
+		for i := 0; i < 100; i++ {
+			suffixes = append(suffixes, fmt.Sprintf("%02d", i))
+		}
+	}
+	if on["years"] {
+		for y := 1950; y <= 2030; y++ {
+			suffixes = append(suffixes, fmt.Sprint(y))
+		}
+	}
+	if on["symbols"] {
+		var more []string
+		for _, s := range suffixes {
+			more = append(more, s)
+			for _, c := range symbols {
+				more = append(more, s+string(c))
+			}
+		}
+		suffixes = more
+	}
+
+	var rules []rule
+	for _, c := range cases {
+		for _, l := range leets {
+			for _, s := range suffixes {
+				rules = append(rules, rule{c, l, s})
+			}
+		}
+	}
+	return rules, nil
+}
+
+// ruleUsage returns the description of the rule sets for the usage message.
+func ruleUsage() string {
+	var names []string
+	for name := range ruleNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var s string
+	for _, name := range names {
+		s += fmt.Sprintf("\t%s\t%s\n", name, ruleNames[name])
+	}
+	return s
+}
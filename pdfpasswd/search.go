@@ -0,0 +1,218 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chunkSize is the number of candidates a worker takes at a time.
+const chunkSize = 1 << 10
+
+// saveInterval is the interval between checkpoints
+// when progress reports are turned off.
+const saveInterval = time.Minute
+
+// A search runs workers over a keyspace, starting at a given candidate.
+// Workers take chunks of candidates in order; the checkpoint is the start
+// of the first chunk not yet finished, so that resuming from it
+// repeats at most a few chunks per worker.
+type search struct {
+	target     string // what is searched for, recorded in the checkpoint
+	space      keyspace
+	check      func(pw string) bool
+	workers    int
+	checkpoint string        // checkpoint file, or ""
+	progress   time.Duration // interval between progress reports, or ≤ 0 for none
+
+	mu    sync.Mutex
+	next  uint64          // start of next chunk to hand out
+	low   uint64          // start of first unfinished chunk
+	done  map[uint64]bool // finished chunks at or above low
+	tried uint64          // candidates tried by this run
+	found *string
+}
+
+// run searches the keyspace from start and returns the password,
+// or ok=false if the keyspace was exhausted or the search interrupted.
+func (s *search) run(start uint64) (password string, ok bool) {
+	s.next = start
+	s.low = start
+	s.done = make(map[uint64]bool)
+
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(stop)
+		}()
+	}
+	finished := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	intr := make(chan os.Signal, 1)
+	signal.Notify(intr, os.Interrupt)
+	defer signal.Stop(intr)
+
+	begin := time.Now()
+	interval := s.progress
+	if interval <= 0 {
+		interval = saveInterval
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-finished:
+			// The search is over; a checkpoint would only repeat it.
+			if s.checkpoint != "" {
+				os.Remove(s.checkpoint)
+			}
+			if s.found != nil {
+				return *s.found, true
+			}
+			return "", false
+		case <-tick.C:
+			if s.progress > 0 {
+				s.report(begin)
+			}
+			s.save()
+		case <-intr:
+			close(stop)
+			<-finished
+			s.save()
+			s.report(begin)
+			log.Printf("interrupted")
+			if s.found != nil {
+				return *s.found, true
+			}
+			return "", false
+		}
+	}
+}
+
+// work tries chunks of candidates until the keyspace is exhausted,
+// the password is found, or stop is closed.
+func (s *search) work(stop chan bool) {
+	var buf []byte
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		s.mu.Lock()
+		if s.found != nil || s.next >= s.space.Len() {
+			s.mu.Unlock()
+			return
+		}
+		lo := s.next
+		hi := lo + chunkSize
+		if hi > s.space.Len() || hi < lo {
+			hi = s.space.Len()
+		}
+		s.next = hi
+		s.mu.Unlock()
+
+		for i := lo; i < hi; i++ {
+			buf = s.space.At(i, buf)
+			if pw := string(buf); s.check(pw) {
+				s.mu.Lock()
+				s.found = &pw
+				s.mu.Unlock()
+				return
+			}
+		}
+
+		s.mu.Lock()
+		s.tried += hi - lo
+		s.done[lo] = true
+		for s.done[s.low] {
+			delete(s.done, s.low)
+			s.low += chunkSize
+		}
+		if s.low > s.space.Len() {
+			s.low = s.space.Len()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// report prints the search's progress and rate.
+func (s *search) report(begin time.Time) {
+	s.mu.Lock()
+	tried, low := s.tried, s.low
+	s.mu.Unlock()
+	total := s.space.Len()
+	rate := float64(tried) / time.Since(begin).Seconds()
+	msg := fmt.Sprintf("%d/%d (%.1f%%) tried, %.0f/s", low, total, 100*float64(low)/float64(total), rate)
+	if rate > 0 {
+		left := time.Duration(float64(total-low)/rate) * time.Second
+		msg += fmt.Sprintf(", %v left", left)
+	}
+	if low < total {
+		msg += ", at " + show(s.space, s.space.At(low, nil))
+	}
+	log.Print(msg)
+}
+
+// save writes the checkpoint file, if any.
+func (s *search) save() {
+	if s.checkpoint == "" {
+		return
+	}
+	s.mu.Lock()
+	low := s.low
+	s.mu.Unlock()
+	data := fmt.Sprintf("%s\n%s\n%d\n", s.target, s.space, low)
+	tmp := s.checkpoint + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(data), 0666); err != nil {
+		log.Print(err)
+		return
+	}
+	if err := os.Rename(tmp, s.checkpoint); err != nil {
+		log.Print(err)
+	}
+}
+
+// loadCheckpoint returns the candidate at which to resume the search
+// for target over space recorded in file.
+// A missing file means starting from the beginning.
+func loadCheckpoint(file, target string, space keyspace) (uint64, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 3 {
+		return 0, fmt.Errorf("%s: malformed checkpoint", file)
+	}
+	if lines[0] != target {
+		return 0, fmt.Errorf("%s: checkpoint is for a different file or password (%s)", file, lines[0])
+	}
+	if lines[1] != space.String() {
+		return 0, fmt.Errorf("%s: checkpoint is for a different search (%s)", file, lines[1])
+	}
+	var start uint64
+	if _, err := fmt.Sscan(lines[2], &start); err != nil || start > space.Len() {
+		return 0, fmt.Errorf("%s: malformed checkpoint", file)
+	}
+	return start, nil
+}
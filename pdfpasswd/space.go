@@ -0,0 +1,261 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+)
+
+// A keyspace is a numbered set of candidate passwords.
+type keyspace interface {
+	// Len returns the number of candidates.
+	Len() uint64
+
+	// At returns candidate i, using buf as storage if possible.
+	At(i uint64, buf []byte) []byte
+
+	// String describes the keyspace, for checking that
+	// a checkpoint belongs to the same search.
+	String() string
+}
+
+// A bruteSpace holds all strings over an alphabet with length between
+// min and max, shortest first.
+type bruteSpace struct {
+	alpha    string
+	min, max int
+	counts   []uint64 // counts[n] is the number of candidates of length min+n
+}
+
+func newBruteSpace(alpha string, min, max int) (*bruteSpace, error) {
+	if alpha == "" {
+		return nil, fmt.Errorf("empty alphabet")
+	}
+	if min < 0 || max < min {
+		return nil, fmt.Errorf("invalid length range %d-%d", min, max)
+	}
+	s := &bruteSpace{alpha: alpha, min: min, max: max}
+	var total uint64
+	for n := min; n <= max; n++ {
+		c := uint64(1)
+		for i := 0; i < n; i++ {
+			if c > math.MaxUint64/uint64(len(alpha)) {
+				return nil, fmt.Errorf("too many candidates")
+			}
+			c *= uint64(len(alpha))
+		}
+		if total+c < total {
+			return nil, fmt.Errorf("too many candidates")
+		}
+		total += c
+		s.counts = append(s.counts, c)
+	}
+	return s, nil
+}
+
+func (s *bruteSpace) Len() uint64 {
+	var total uint64
+	for _, c := range s.counts {
+		total += c
+	}
+	return total
+}
+
+func (s *bruteSpace) At(i uint64, buf []byte) []byte {
+	n := s.min
+	for _, c := range s.counts {
+		if i < c {
+			break
+		}
+		i -= c
+		n++
+	}
+	buf = append(buf[:0], make([]byte, n)...)
+	// The first character varies fastest.
+	a := uint64(len(s.alpha))
+	for j := 0; j < n; j++ {
+		buf[j] = s.alpha[i%a]
+		i /= a
+	}
+	return buf
+}
+
+func (s *bruteSpace) String() string {
+	return fmt.Sprintf("brute %q %d %d", s.alpha, s.min, s.max)
+}
+
+// A maskSpace holds the strings matching a mask, which gives
+// the set of characters allowed at each position.
+type maskSpace struct {
+	mask string
+	sets []string
+}
+
+// Built-in character sets for masks, as in hashcat.
+var maskSets = map[byte]string{
+	'l': "abcdefghijklmnopqrstuvwxyz",
+	'u': "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	'd': "0123456789",
+	'h': "0123456789abcdef",
+	'H': "0123456789ABCDEF",
+	's': " !\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~",
+}
+
+func init() {
+	maskSets['a'] = maskSets['l'] + maskSets['u'] + maskSets['d'] + maskSets['s']
+}
+
+// newMaskSpace parses mask, in which ?l, ?u, ?d, ?h, ?H, ?s and ?a
+// stand for the built-in character sets, ?1 through ?4 stand for
+// custom[0] through custom[3], ?? stands for a question mark, and
+// any other character stands for itself.
+func newMaskSpace(mask string, custom []string) (*maskSpace, error) {
+	s := &maskSpace{mask: mask}
+	for i := 0; i < len(mask); i++ {
+		if mask[i] != '?' {
+			s.sets = append(s.sets, mask[i:i+1])
+			continue
+		}
+		if i++; i == len(mask) {
+			return nil, fmt.Errorf("invalid mask %q: trailing ?", mask)
+		}
+		c := mask[i]
+		set := maskSets[c]
+		switch {
+		case c == '?':
+			set = "?"
+		case '1' <= c && c <= '4':
+			set = custom[c-'1']
+		}
+		if set == "" {
+			return nil, fmt.Errorf("invalid mask %q: undefined charset ?%c", mask, c)
+		}
+		s.sets = append(s.sets, set)
+	}
+	n := uint64(1)
+	for _, set := range s.sets {
+		if n > math.MaxUint64/uint64(len(set)) {
+			return nil, fmt.Errorf("too many candidates")
+		}
+		n *= uint64(len(set))
+	}
+	return s, nil
+}
+
+func (s *maskSpace) Len() uint64 {
+	n := uint64(1)
+	for _, set := range s.sets {
+		n *= uint64(len(set))
+	}
+	return n
+}
+
+func (s *maskSpace) At(i uint64, buf []byte) []byte {
+	buf = append(buf[:0], make([]byte, len(s.sets))...)
+	// The last character varies fastest.
+	for j := len(s.sets) - 1; j >= 0; j-- {
+		set := s.sets[j]
+		buf[j] = set[i%uint64(len(set))]
+		i /= uint64(len(set))
+	}
+	return buf
+}
+
+func (s *maskSpace) String() string {
+	return fmt.Sprintf("mask %q %q", s.mask, s.sets)
+}
+
+// A wordSpace holds the results of applying rules to the words
+// in a wordlist: every rule is applied to the first word,
+// then every rule to the second word, and so on.
+type wordSpace struct {
+	source string // name of the wordlist
+	words  []string
+	spec   string // rules, as given to parseRules
+	rules  []rule
+}
+
+// readWords reads a wordlist, one word per line, from file,
+// or from standard input if file is "-".
+func readWords(file string) ([]string, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var words []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line != "" {
+			words = append(words, line)
+		}
+	}
+	return words, nil
+}
+
+func newWordSpace(file, spec string) (*wordSpace, error) {
+	rules, err := parseRules(spec)
+	if err != nil {
+		return nil, err
+	}
+	words, err := readWords(file)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(words)) > math.MaxUint64/uint64(len(rules)) {
+		return nil, fmt.Errorf("too many candidates")
+	}
+	return &wordSpace{source: file, words: words, spec: spec, rules: rules}, nil
+}
+
+func (s *wordSpace) Len() uint64 {
+	return uint64(len(s.words)) * uint64(len(s.rules))
+}
+
+func (s *wordSpace) At(i uint64, buf []byte) []byte {
+	n := uint64(len(s.rules))
+	return s.rules[i%n].apply(buf[:0], s.words[i/n])
+}
+
+func (s *wordSpace) String() string {
+	// The word count catches a changed wordlist, or different input on stdin.
+	return fmt.Sprintf("words %q %d %q", s.source, len(s.words), s.spec)
+}
+
+// A keySpace holds all 40-bit file keys.
+type keySpace struct{}
+
+func (keySpace) Len() uint64 {
+	return 1 << 40
+}
+
+func (keySpace) At(i uint64, buf []byte) []byte {
+	buf = buf[:0]
+	for j := 0; j < 5; j++ {
+		buf = append(buf, byte(i>>(8*uint(j))))
+	}
+	return buf
+}
+
+func (keySpace) String() string {
+	return "key 40"
+}
+
+// show formats candidate b from space for printing.
+func show(space keyspace, b []byte) string {
+	if _, ok := space.(keySpace); ok {
+		return fmt.Sprintf("%x", b)
+	}
+	return fmt.Sprintf("%q", b)
+}
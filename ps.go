@@ -5,6 +5,7 @@
 package pdf
 
 import (
+	"context"
 	"fmt"
 	"io"
 )
@@ -34,7 +35,7 @@ func (stk *Stack) Pop() Value {
 }
 
 func newDict() Value {
-	return Value{nil, objptr{}, make(dict)}
+	return Value{nil, objptr{}, make(dict), nil, false}
 }
 
 // Interpret interprets the content in a stream as a basic PostScript program,
@@ -51,9 +52,31 @@ func newDict() Value {
 //
 // There is no support for executable blocks, among other limitations.
 //
+// Interpret panics if the stream cannot be read or parsed, or if do panics.
+// The panic value is an *Error recording the offset in the decoded stream
+// at which the problem occurred.
+//
 func Interpret(strm Value, do func(stk *Stack, op string)) {
-	rd := strm.Reader()
+	InterpretContext(context.Background(), strm, do)
+}
+
+// InterpretContext is like Interpret but stops once ctx is cancelled,
+// checking before each operator and each read of the stream.
+// It then panics with an *Error wrapping ctx.Err().
+func InterpretContext(ctx context.Context, strm Value, do func(stk *Stack, op string)) {
+	rd, err := strm.ReaderContext(ctx)
+	if err != nil {
+		panic(err)
+	}
 	b := newBuffer(rd, 0)
+	if strm.r != nil {
+		b.maxDepth = strm.r.limits.MaxDepth
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			panic(toError(e, strm.ptr, b.readOffset()))
+		}
+	}()
 	b.allowEOF = true
 	b.allowObjptr = false
 	b.allowStream = false
@@ -72,21 +95,24 @@ Reading:
 			default:
 				for i := len(dicts) - 1; i >= 0; i-- {
 					if v, ok := dicts[i][name(kw)]; ok {
-						stk.Push(Value{nil, objptr{}, v})
+						stk.Push(Value{nil, objptr{}, v, nil, false})
 						continue Reading
 					}
 				}
+				if err := ctx.Err(); err != nil {
+					panic(err)
+				}
 				do(&stk, string(kw))
 				continue
 			case "dict":
 				stk.Pop()
-				stk.Push(Value{nil, objptr{}, make(dict)})
+				stk.Push(Value{nil, objptr{}, make(dict), nil, false})
 				continue
 			case "currentdict":
 				if len(dicts) == 0 {
 					panic("no current dictionary")
 				}
-				stk.Push(Value{nil, objptr{}, dicts[len(dicts)-1]})
+				stk.Push(Value{nil, objptr{}, dicts[len(dicts)-1], nil, false})
 				continue
 			case "begin":
 				d := stk.Pop()
@@ -119,7 +145,7 @@ Reading:
 		}
 		b.unreadToken(tok)
 		obj := b.readObject()
-		stk.Push(Value{nil, objptr{}, obj})
+		stk.Push(Value{nil, objptr{}, obj, nil, false})
 	}
 }
 
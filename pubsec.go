@@ -0,0 +1,290 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Decryption of files encrypted for recipients' certificates
+// (public-key security handler).
+
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+var ErrNotRecipient = fmt.Errorf("encrypted PDF: not encrypted for this certificate")
+
+// pubSecKey computes the file key for a file encrypted with the
+// public-key security handler (PDF 32000-1:2008, §7.6.4), using the
+// recipient's certificate and private key from opts.
+// With crypt filters, kf is the filter returned by keyFilter.
+// It returns the key and the permissions granted to the recipient.
+func pubSecKey(encrypt Value, V int64, kf cryptFilter, opts *ReaderOptions) ([]byte, Permissions, error) {
+	switch sub := encrypt.Key("SubFilter").Name(); sub {
+	case "adbe.pkcs7.s3", "adbe.pkcs7.s4", "adbe.pkcs7.s5":
+	default:
+		return nil, 0, fmt.Errorf("unsupported PDF: public-key encryption SubFilter %s", sub)
+	}
+
+	// With crypt filters (V ≥ 4), the recipients and the method
+	// are given by the filter used for streams.
+	recipients := encrypt.Key("Recipients")
+	bits := encrypt.Key("Length").Int64()
+	cfm := "V2"
+	if V >= 4 {
+		f := encrypt.Key("StmF").Name()
+		if f == "" || f == "Identity" {
+			f = encrypt.Key("StrF").Name()
+		}
+		cf := encrypt.Key("CF").Key(f)
+		recipients = cf.Key("Recipients")
+		cfm = cf.Key("CFM").Name()
+		if kf.bits != 0 {
+			bits = int64(kf.bits)
+		}
+	}
+	if bits == 0 {
+		bits = 40
+	}
+	h := sha1.New()
+	switch cfm {
+	case "V2":
+		if bits%8 != 0 || bits > 128 || bits < 40 {
+			return nil, 0, fmt.Errorf("malformed PDF: %d-bit encryption key", bits)
+		}
+	case "AESV2":
+		bits = 128
+	case "AESV3":
+		bits = 256
+		h = sha256.New()
+	default:
+		return nil, 0, fmt.Errorf("unsupported PDF: public-key encryption method %s", cfm)
+	}
+	if recipients.Kind() != Array && recipients.Kind() != String {
+		return nil, 0, fmt.Errorf("malformed PDF: missing Recipients")
+	}
+
+	if opts.PrivateKey == nil {
+		return nil, 0, fmt.Errorf("encrypted PDF: certificate and private key required")
+	}
+	priv, ok := opts.PrivateKey.(crypto.Decrypter)
+	if !ok {
+		return nil, 0, fmt.Errorf("encrypted PDF: private key of type %T cannot decrypt", opts.PrivateKey)
+	}
+
+	// The recipient's envelope holds a 20-byte seed followed by
+	// the 4-byte permissions granted to the recipient.
+	// The key is a hash of the seed and all the envelopes.
+	var seed []byte
+	var envelopes []string
+	if recipients.Kind() == String {
+		envelopes = append(envelopes, recipients.RawString())
+	}
+	for i := 0; i < recipients.Len(); i++ {
+		envelopes = append(envelopes, recipients.Index(i).RawString())
+	}
+	for _, env := range envelopes {
+		if seed != nil {
+			break
+		}
+		data, err := openEnvelope([]byte(env), opts.Certificate, priv)
+		if err != nil {
+			return nil, 0, err
+		}
+		if data != nil {
+			if len(data) < 24 {
+				return nil, 0, fmt.Errorf("malformed PDF: short public-key envelope")
+			}
+			seed = data
+		}
+	}
+	if seed == nil {
+		return nil, 0, ErrNotRecipient
+	}
+
+	h.Write(seed[:20])
+	for _, env := range envelopes {
+		h.Write([]byte(env))
+	}
+	if V >= 4 && !encryptMetadata(encrypt) {
+		h.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	}
+	key := h.Sum(nil)[:bits/8]
+	perms := Permissions(binary.BigEndian.Uint32(seed[20:24])) & allPermissions
+	return key, perms, nil
+}
+
+// encryptMetadata reports whether the document's metadata stream is
+// encrypted, as given by the encryption dictionary or, for the public-key
+// security handler, by the crypt filter used for streams.
+func encryptMetadata(encrypt Value) bool {
+	if encrypt.Key("EncryptMetadata").Kind() == Bool {
+		return encrypt.Key("EncryptMetadata").Bool()
+	}
+	m := encrypt.Key("CF").Key(encrypt.Key("StmF").Name()).Key("EncryptMetadata")
+	return m.Kind() != Bool || m.Bool()
+}
+
+// ASN.1 structures for PKCS #7 (CMS) enveloped data; see RFC 5652.
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"tag:0,optional"`
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	RecipientID            asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+var (
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSAOAEP       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidDESCBC        = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 7}
+	oidDESEDE3CBC    = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// openEnvelope decrypts the PKCS #7 enveloped data env using the
+// private key priv. If cert is not nil, only the recipient information
+// matching cert is used. If env has no recipient information that
+// priv can decrypt, openEnvelope returns nil, nil.
+func openEnvelope(env []byte, cert *x509.Certificate, priv crypto.Decrypter) ([]byte, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(env, &ci); err != nil {
+		return nil, fmt.Errorf("malformed PDF: public-key envelope: %v", err)
+	}
+	if !ci.ContentType.Equal(oidEnvelopedData) {
+		return nil, fmt.Errorf("malformed PDF: public-key envelope has content type %v", ci.ContentType)
+	}
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+		return nil, fmt.Errorf("malformed PDF: public-key envelope: %v", err)
+	}
+
+	var cek []byte
+	for _, raw := range ed.RecipientInfos {
+		var ri keyTransRecipientInfo
+		if _, err := asn1.Unmarshal(raw.FullBytes, &ri); err != nil {
+			continue // some other kind of recipient
+		}
+		if cert != nil && !matchRecipient(ri.RecipientID, cert) {
+			continue
+		}
+		var opts crypto.DecrypterOpts
+		switch {
+		case ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAEncryption):
+			opts = &rsa.PKCS1v15DecryptOptions{}
+		case ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAOAEP):
+			opts = &rsa.OAEPOptions{Hash: crypto.SHA1}
+		default:
+			continue
+		}
+		k, err := priv.Decrypt(rand.Reader, ri.EncryptedKey, opts)
+		if err == nil {
+			cek = k
+			break
+		}
+	}
+	if cek == nil {
+		return nil, nil
+	}
+
+	eci := ed.EncryptedContentInfo
+	var block cipher.Block
+	var err error
+	switch alg := eci.ContentEncryptionAlgorithm.Algorithm; {
+	case alg.Equal(oidDESCBC):
+		block, err = des.NewCipher(cek)
+	case alg.Equal(oidDESEDE3CBC):
+		block, err = des.NewTripleDESCipher(cek)
+	case alg.Equal(oidAES128CBC), alg.Equal(oidAES192CBC), alg.Equal(oidAES256CBC):
+		block, err = aes.NewCipher(cek)
+	default:
+		return nil, fmt.Errorf("unsupported PDF: public-key envelope encrypted with %v", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed PDF: public-key envelope: %v", err)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil || len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("malformed PDF: public-key envelope: invalid IV")
+	}
+	data := envelopeContent(eci.EncryptedContent)
+	if len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("malformed PDF: public-key envelope: invalid content")
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+	if n := int(data[len(data)-1]); 1 <= n && n <= block.BlockSize() {
+		data = data[:len(data)-n]
+	}
+	return data, nil
+}
+
+// envelopeContent returns a copy of the encrypted content,
+// which may be a primitive octet string or, in BER,
+// a constructed one made up of primitive octet strings.
+func envelopeContent(v asn1.RawValue) []byte {
+	if !v.IsCompound {
+		return append([]byte(nil), v.Bytes...)
+	}
+	var data []byte
+	for rest := v.Bytes; len(rest) > 0; {
+		var part asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &part); err != nil {
+			return nil
+		}
+		data = append(data, part.Bytes...)
+	}
+	return data
+}
+
+// matchRecipient reports whether the recipient identifier id,
+// an issuer and serial number or a subject key identifier,
+// identifies cert.
+func matchRecipient(id asn1.RawValue, cert *x509.Certificate) bool {
+	if id.Class == asn1.ClassContextSpecific && id.Tag == 0 {
+		return bytes.Equal(id.Bytes, cert.SubjectKeyId)
+	}
+	var ias issuerAndSerialNumber
+	if _, err := asn1.Unmarshal(id.FullBytes, &ias); err != nil {
+		return false
+	}
+	return bytes.Equal(ias.Issuer.FullBytes, cert.RawIssuer) && ias.SerialNumber.Cmp(cert.SerialNumber) == 0
+}
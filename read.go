@@ -49,41 +49,62 @@ package pdf
 // BUG(rsc): The package is incomplete, although it has been used successfully on some
 // large real-world PDF files.
 
-// BUG(rsc): There is no support for closing open PDF files. If you drop all references to a Reader,
-// the underlying reader will eventually be garbage collected.
-
-// BUG(rsc): The library makes no attempt at efficiency. A value cache maintained in the Reader
-// would probably help significantly.
-
 // BUG(rsc): The support for reading encrypted files is weak.
 
-// BUG(rsc): The Value API does not support error reporting. The intent is to allow users to
-// set an error reporting callback in Reader, but that code has not been implemented.
-
 import (
 	"bytes"
-	"compress/zlib"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/md5"
-	"crypto/rc4"
+	"context"
+	"crypto"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 // A Reader is a single PDF file open for reading.
+//
+// A Reader, and the Values, Pages and Fonts obtained from it, may be used
+// by multiple goroutines simultaneously, except that SetErrorHandler and
+// Close must not be called concurrently with other methods.
+// The error handler may be called by several goroutines at once.
 type Reader struct {
+	ctx        context.Context // cancels opening; nil once open
 	f          io.ReaderAt
 	end        int64
+	version    string
 	xref       []xref
 	trailer    dict
 	trailerptr objptr
+	revs       []xrefRevision // newest first
 	key        []byte
-	useAES     bool
+	stmCrypt   cryptMethod // default crypt filter for streams
+	strCrypt   cryptMethod // crypt filter for strings
+	errh       func(error)
+	closer     io.Closer
+	strict     bool
+	logger     *log.Logger
+	warnh      func(Warning)
+	limits     Limits
+
+	cryptFilters    map[name]cryptFilter
+	encryptMetadata bool
+	perms           Permissions
+	passwordKind    PasswordKind
+
+	cache    *objCache // loaded objects
+	stmCache *objCache // decoded object streams
+
+	scanOnce sync.Once
+	scan     *scanResult
+	rebuilt  bool   // xref table was rebuilt by scanning the file
+	noScan   bool   // objects must not be recovered by scanning the file
+	repaired uint32 // accessed atomically
 }
 
 type xref struct {
@@ -93,13 +114,113 @@ type xref struct {
 	offset   int64
 }
 
+// checkEvery is how many cross-reference entries are read
+// between checks for cancellation.
+const checkEvery = 4096
+
+// canceled returns the error recording the cancellation
+// of the Reader's opening, if any.
+func (r *Reader) canceled() error {
+	if r.ctx == nil {
+		return nil
+	}
+	return r.ctx.Err()
+}
+
 func (r *Reader) errorf(format string, args ...interface{}) {
-	panic(fmt.Errorf(format, args...))
+	panic(&Error{Offset: -1, Err: fmt.Errorf(format, args...)})
+}
+
+// An Error describes a problem found while reading a PDF file.
+//
+// Offset is relative to the start of the file, except for problems found
+// while parsing the decoded data of a stream (an object stream or a page's
+// content stream), in which case it is relative to the start of that data.
+type Error struct {
+	ID     uint32 // number of the object being read, or 0 if unknown
+	Gen    uint16 // generation of the object being read
+	Offset int64  // byte offset of the problem, or -1 if unknown
+	Err    error  // the underlying error
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.ID != 0 && e.Offset >= 0:
+		return fmt.Sprintf("object %d %d at offset %d: %v", e.ID, e.Gen, e.Offset, e.Err)
+	case e.ID != 0:
+		return fmt.Sprintf("object %d %d: %v", e.ID, e.Gen, e.Err)
+	case e.Offset >= 0:
+		return fmt.Sprintf("offset %d: %v", e.Offset, e.Err)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// toError converts a value recovered from a panic raised during reading
+// into an *Error, filling in any location that the panic did not record.
+func toError(e interface{}, ptr objptr, offset int64) *Error {
+	var err Error
+	switch e := e.(type) {
+	case *Error:
+		err = *e
+	case error:
+		err = Error{Offset: -1, Err: e}
+	default:
+		err = Error{Offset: -1, Err: fmt.Errorf("%v", e)}
+	}
+	if err.ID == 0 {
+		err.ID, err.Gen = ptr.id, ptr.gen
+	}
+	if err.Offset < 0 {
+		err.Offset = offset
+	}
+	return &err
+}
+
+// catch recovers from a panic raised during reading and stores it in *err.
+// It must be called directly by a deferred statement.
+func catch(err *error, ptr objptr, offset int64) {
+	if e := recover(); e != nil {
+		*err = toError(e, ptr, offset)
+	}
+}
+
+// SetErrorHandler arranges for h to be called with each error encountered
+// by the methods that do not return errors themselves,
+// such as Value.Key, Value.Reader, and Page.Content.
+// Those methods continue to return null Values or partial results;
+// h only makes the problems visible.
+// By default such errors are discarded.
+// SetErrorHandler should be called before r is shared between goroutines.
+func (r *Reader) SetErrorHandler(h func(err error)) {
+	r.errh = h
+}
+
+func (r *Reader) report(err error) {
+	if r != nil && r.errh != nil {
+		r.errh(err)
+	}
 }
 
 // Open opens a file for reading.
+// The caller should call Close on the Reader when done with it.
 func Open(file string) (*Reader, error) {
-	// TODO: Deal with closing file.
+	return OpenOptions(file, nil)
+}
+
+// OpenOptions opens a file for reading, configured by opts.
+// The caller should call Close on the Reader when done with it.
+func OpenOptions(file string, opts *ReaderOptions) (*Reader, error) {
+	return OpenContext(context.Background(), file, opts)
+}
+
+// OpenContext is like OpenOptions but stops reading the file
+// and returns ctx.Err() if ctx is cancelled before the file is open.
+func OpenContext(ctx context.Context, file string, opts *ReaderOptions) (*Reader, error) {
 	f, err := os.Open(file)
 	if err != nil {
 		return nil, err
@@ -109,12 +230,18 @@ func Open(file string) (*Reader, error) {
 		f.Close()
 		return nil, err
 	}
-	return NewReader(f, fi.Size())
+	r, err := NewReaderContext(ctx, f, fi.Size(), opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
 }
 
 // NewReader opens a file for reading, using the data in f with the given total size.
 func NewReader(f io.ReaderAt, size int64) (*Reader, error) {
-	return NewReaderEncrypted(f, size, nil)
+	return NewReaderOptions(f, size, nil)
 }
 
 // NewReaderEncrypted opens a file for reading, using the data in f with the given total size.
@@ -122,148 +249,530 @@ func NewReader(f io.ReaderAt, size int64) (*Reader, error) {
 // to try. If pw returns the empty string, NewReaderEncrypted stops trying to decrypt
 // the file and returns an error.
 func NewReaderEncrypted(f io.ReaderAt, size int64, pw func() string) (*Reader, error) {
-	buf := make([]byte, 10)
-	f.ReadAt(buf, 0)
-	if !bytes.HasPrefix(buf, []byte("%PDF-1.")) || buf[7] < '0' || buf[7] > '7' || buf[8] != '\r' && buf[8] != '\n' {
-		return nil, fmt.Errorf("not a PDF file: invalid header")
-	}
-	end := size
-	const endChunk = 100
-	buf = make([]byte, endChunk)
-	f.ReadAt(buf, end-endChunk)
-	for len(buf) > 0 && buf[len(buf)-1] == '\n' || buf[len(buf)-1] == '\r' {
-		buf = buf[:len(buf)-1]
-	}
-	buf = bytes.TrimRight(buf, "\r\n\t ")
-	if !bytes.HasSuffix(buf, []byte("%%EOF")) {
-		return nil, fmt.Errorf("not a PDF file: missing %%%%EOF")
+	return NewReaderOptions(f, size, &ReaderOptions{Password: pw})
+}
+
+// ReaderOptions holds optional settings for opening a PDF file.
+// A nil *ReaderOptions is equivalent to a zero ReaderOptions,
+// which gives the behavior of NewReader.
+type ReaderOptions struct {
+	// Passwords lists passwords to try, in order, if the file is encrypted.
+	// The empty password is always tried first. If it opens the file,
+	// Passwords are still tried in case one of them is the owner password
+	// (see Reader.PasswordKind).
+	Passwords []string
+
+	// Password, if non-nil, is called repeatedly to obtain more passwords
+	// to try once those in Passwords have failed, as in NewReaderEncrypted.
+	// Returning the empty string stops the search.
+	Password func() string
+
+	// FileKey, if non-nil, is the file encryption key of a file encrypted
+	// with the standard security handler, such as one found by pdfpasswd -key40.
+	// It is used instead of a password, and only for revisions before 5,
+	// after checking it with EncryptionParams.CheckKey.
+	FileKey []byte
+
+	// Certificate and PrivateKey identify the recipient of a file
+	// encrypted for recipients' certificates (the Adobe.PubSec security
+	// handler), which is decrypted using PrivateKey. PrivateKey must
+	// implement crypto.Decrypter, as *rsa.PrivateKey does.
+	// If Certificate is nil, every recipient in the file is tried.
+	Certificate *x509.Certificate
+	PrivateKey  crypto.PrivateKey
+
+	// Strict causes problems that the Reader would otherwise work around,
+	// such as invalid cross-reference entries, to be treated as errors.
+	Strict bool
+
+	// Logger, if non-nil, receives a message for each Warning.
+	Logger *log.Logger
+
+	// Warn, if non-nil, is installed using SetWarningHandler,
+	// and also receives the warnings found while opening the file.
+	Warn func(Warning)
+
+	// ErrorHandler, if non-nil, is installed using SetErrorHandler.
+	ErrorHandler func(err error)
+
+	// CacheSize is the number of indirect objects to cache (see SetCacheSize).
+	// Zero selects a default size, and a negative size disables the cache.
+	CacheSize int
+
+	// ObjStmCacheSize is the number of bytes of decoded object streams
+	// to cache (see SetObjStmCacheSize). Zero selects a default size,
+	// and a negative size disables the cache.
+	ObjStmCacheSize int
+
+	// Limits bounds the resources used to read the file.
+	// Some limits apply by default; use NoLimits to turn them off.
+	Limits Limits
+}
+
+// NewReaderOptions opens a file for reading, using the data in f with the given total size,
+// configured by opts.
+func NewReaderOptions(f io.ReaderAt, size int64, opts *ReaderOptions) (*Reader, error) {
+	return NewReaderContext(context.Background(), f, size, opts)
+}
+
+// NewReaderContext is like NewReaderOptions but stops reading the file
+// and returns ctx.Err() if ctx is cancelled before the file is open.
+// Once the Reader is returned, ctx has no effect on it.
+func NewReaderContext(ctx context.Context, f io.ReaderAt, size int64, opts *ReaderOptions) (_ *Reader, err error) {
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+	defer catch(&err, objptr{}, -1)
+
+	if opts == nil {
+		opts = new(ReaderOptions)
 	}
-	i := findLastLine(buf, "startxref")
-	if i < 0 {
-		return nil, fmt.Errorf("malformed PDF file: missing final startxref")
+	r, err := newReader(ctx, f, size, opts)
+	if err != nil {
+		return nil, err
 	}
+	if err := r.authenticate(opts); err != nil {
+		return nil, err
+	}
+	if r.rebuilt {
+		r.addScannedObjStms()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.ctx = nil
+	return r, nil
+}
+
+// newReader reads the header, cross-reference table and trailer of f,
+// without decrypting the file. A nil opts selects the defaults.
+// The returned Reader checks ctx for cancellation until r.ctx is cleared.
+func newReader(ctx context.Context, f io.ReaderAt, size int64, opts *ReaderOptions) (_ *Reader, err error) {
+	defer catch(&err, objptr{}, -1)
 
+	if opts == nil {
+		opts = new(ReaderOptions)
+	}
+
+	base, version, err := findHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	if base > 0 {
+		// Offsets in the file are relative to the header.
+		if opts.Strict {
+			return nil, fmt.Errorf("malformed PDF: %d bytes before header", base)
+		}
+		f = io.NewSectionReader(f, base, size-base)
+		size -= base
+	}
+
+	cacheSize := opts.CacheSize
+	if cacheSize == 0 {
+		cacheSize = defaultCacheSize
+	}
+	stmCacheSize := opts.ObjStmCacheSize
+	if stmCacheSize == 0 {
+		stmCacheSize = defaultObjStmCacheSize
+	}
 	r := &Reader{
-		f:   f,
-		end: end,
+		ctx:      ctx,
+		f:        f,
+		end:      size,
+		version:  version,
+		errh:     opts.ErrorHandler,
+		strict:   opts.Strict,
+		logger:   opts.Logger,
+		warnh:    opts.Warn,
+		limits:   opts.Limits.effective(),
+		cache:    newObjCache(cacheSize),
+		stmCache: newObjCache(stmCacheSize),
+	}
+	if err := r.readTrailer(); err != nil {
+		if r.strict || isLimit(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		r.warnf("xref", objptr{}, -1, "rebuilding cross-reference table: %v", err)
+		if err := r.rebuild(); err != nil {
+			return nil, err
+		}
 	}
-	pos := end - endChunk + int64(i)
-	b := newBuffer(io.NewSectionReader(f, pos, end-pos), pos)
+	return r, nil
+}
+
+// readTrailer reads the cross-reference table and trailer
+// found by following the startxref pointer at the end of the file.
+func (r *Reader) readTrailer() (err error) {
+	defer catch(&err, objptr{}, -1)
+
+	// Look for the final %%EOF near the end of the file,
+	// allowing for junk appended after it.
+	end := r.end
+	const endChunk = 1024
+	start := end - endChunk
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, end-start)
+	n, _ := r.f.ReadAt(buf, start)
+	buf = buf[:n]
+	j := bytes.LastIndex(buf, []byte("%%EOF"))
+	if j < 0 {
+		return fmt.Errorf("not a PDF file: missing %%%%EOF")
+	}
+	if r.strict && len(bytes.TrimRight(buf[j+len("%%EOF"):], "\r\n\t \x00")) > 0 {
+		return fmt.Errorf("malformed PDF file: data after %%%%EOF")
+	}
+	buf = buf[:j]
+	i := findLastLine(buf, "startxref")
+	if i < 0 {
+		return fmt.Errorf("malformed PDF file: missing final startxref")
+	}
+
+	pos := start + int64(i)
+	b := newBuffer(io.NewSectionReader(r.f, pos, end-pos), pos)
 	if b.readToken() != keyword("startxref") {
-		return nil, fmt.Errorf("malformed PDF file: missing startxref")
+		return fmt.Errorf("malformed PDF file: missing startxref")
 	}
 	startxref, ok := b.readToken().(int64)
 	if !ok {
-		return nil, fmt.Errorf("malformed PDF file: startxref not followed by integer")
+		return fmt.Errorf("malformed PDF file: startxref not followed by integer")
 	}
-	b = newBuffer(io.NewSectionReader(r.f, startxref, r.end-startxref), startxref)
-	xref, trailerptr, trailer, err := readXref(r, b)
+	revs, err := readXref(r, startxref)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	xref, err := mergeXref(r, revs)
+	if err != nil {
+		return err
 	}
 	r.xref = xref
-	r.trailer = trailer
-	r.trailerptr = trailerptr
-	if trailer["Encrypt"] == nil {
-		return r, nil
+	r.trailer = revs[0].sec.trailer
+	r.trailerptr = revs[0].sec.ptr
+	r.revs = revs
+	return nil
+}
+
+// findHeader locates the %PDF-n.m header, which must begin
+// within the first 1024 bytes of f, and returns its offset and the
+// version it gives. Versions 1.0 through 1.7 and 2.0 are accepted.
+func findHeader(f io.ReaderAt) (int64, string, error) {
+	const maxOffset = 1024
+	buf := make([]byte, maxOffset+len("%PDF-n.m "))
+	n, _ := f.ReadAt(buf, 0)
+	buf = buf[:n]
+	for off := 0; off <= maxOffset; {
+		i := bytes.Index(buf[off:], []byte("%PDF-"))
+		if i < 0 {
+			break
+		}
+		i += off
+		if i > maxOffset {
+			break
+		}
+		v := buf[i+len("%PDF-"):]
+		if len(v) >= 3 && v[1] == '.' && (v[0] == '1' && '0' <= v[2] && v[2] <= '7' || v[0] == '2' && v[2] == '0') && (len(v) == 3 || !isDigit(v[3])) {
+			return int64(i), string(v[:3]), nil
+		}
+		off = i + 1
 	}
-	err = r.initEncrypt("")
-	if err == nil {
-		return r, nil
+	return 0, "", fmt.Errorf("not a PDF file: invalid header")
+}
+
+// HeaderVersion returns the PDF version given in the file's header,
+// such as "1.7" or "2.0".
+func (r *Reader) HeaderVersion() string {
+	return r.version
+}
+
+// Version returns the version of the PDF specification that the document
+// claims to follow. That is the version in the document catalog's Version
+// entry if it is later than the header's version, and otherwise the
+// header's version.
+func (r *Reader) Version() string {
+	v := r.Trailer().Key("Root").Key("Version").Name()
+	if versionLess(r.version, v) {
+		return v
 	}
-	if pw == nil || err != ErrInvalidPassword {
-		return nil, err
+	return r.version
+}
+
+// versionLess reports whether PDF version a is earlier than version b.
+// A version that is not of the form n.m is treated as earliest.
+func versionLess(a, b string) bool {
+	amaj, amin, ok := parseVersion(a)
+	if !ok {
+		_, _, ok = parseVersion(b)
+		return ok
 	}
-	for {
-		next := pw()
-		if next == "" {
+	bmaj, bmin, ok := parseVersion(b)
+	if !ok {
+		return false
+	}
+	return amaj < bmaj || amaj == bmaj && amin < bmin
+}
+
+func parseVersion(v string) (major, minor int64, ok bool) {
+	i := strings.Index(v, ".")
+	if i < 0 {
+		return 0, 0, false
+	}
+	major, ok1 := parseDecimal([]byte(v[:i]))
+	minor, ok2 := parseDecimal([]byte(v[i+1:]))
+	return major, minor, ok1 && ok2 && i > 0 && i+1 < len(v)
+}
+
+// authenticate sets up decryption for an encrypted file,
+// trying the passwords supplied by opts.
+func (r *Reader) authenticate(opts *ReaderOptions) error {
+	if r.trailer["Encrypt"] == nil {
+		return nil
+	}
+	return r.initEncrypt(opts)
+}
+
+// Close releases the resources held by r.
+// If r was created by Open or OpenOptions, Close closes the underlying file.
+// A Reader must not be used after it has been closed.
+func (r *Reader) Close() error {
+	r.cache.setMax(0)
+	r.stmCache.setMax(0)
+	if r.closer == nil {
+		return nil
+	}
+	err := r.closer.Close()
+	r.closer = nil
+	return err
+}
+
+// Trailer returns the file's Trailer value.
+func (r *Reader) Trailer() Value {
+	return Value{r, r.trailerptr, r.trailer, nil, false}
+}
+
+// An xrefSection is a single cross-reference section, either a
+// cross-reference table with its trailer or a cross-reference stream.
+type xrefSection struct {
+	offset  int64
+	ptr     objptr // the stream object, for a cross-reference stream
+	trailer dict   // the trailer or the stream's dictionary
+	entries []xrefEntry
+}
+
+// An xrefEntry is a single entry in a cross-reference section.
+// For a free entry, only x.ptr is meaningful.
+type xrefEntry struct {
+	x    xref
+	free bool
+}
+
+// An xrefRevision holds the cross-reference data added by one revision
+// of the file: a section and, in a hybrid-reference file, the stream
+// section named by its XRefStm entry.
+type xrefRevision struct {
+	sec  *xrefSection
+	xsec *xrefSection // XRefStm section, or nil
+}
+
+// readXref reads the cross-reference sections starting at offset start,
+// following the Prev chain. It returns one xrefRevision per section
+// in the chain, newest first.
+// Each section may be a table or a stream, and a table may refer to
+// a stream holding additional entries through the XRefStm key,
+// as in hybrid-reference files.
+func readXref(r *Reader, start int64) ([]xrefRevision, error) {
+	var revs []xrefRevision
+	seen := make(map[int64]bool)
+	for off := start; ; {
+		if err := r.canceled(); err != nil {
+			return nil, err
+		}
+		if seen[off] {
+			return nil, fmt.Errorf("malformed PDF: xref Prev loop at offset %d", off)
+		}
+		seen[off] = true
+		sec, err := readXrefSection(r, off)
+		if err != nil {
+			return nil, err
+		}
+		rev := xrefRevision{sec: sec}
+		if xoff, ok := sec.trailer["XRefStm"]; ok && sec.ptr == (objptr{}) {
+			off, ok := xoff.(int64)
+			if !ok {
+				return nil, fmt.Errorf("malformed PDF: XRefStm is not integer: %v", objfmt(xoff))
+			}
+			rev.xsec, err = readXrefSection(r, off)
+			if err != nil {
+				return nil, fmt.Errorf("reading XRefStm: %v", err)
+			}
+			if rev.xsec.ptr == (objptr{}) {
+				return nil, fmt.Errorf("malformed PDF: XRefStm does not point to xref stream")
+			}
+		}
+		revs = append(revs, rev)
+
+		prev, ok := sec.trailer["Prev"]
+		if !ok {
 			break
 		}
-		if r.initEncrypt(next) == nil {
-			return r, nil
+		if off, ok = prev.(int64); !ok {
+			return nil, fmt.Errorf("malformed PDF: xref Prev is not integer: %v", objfmt(prev))
 		}
 	}
-	return nil, err
+
+	// In a linearized file, the first-page section near the start of the
+	// file refers through Prev to the main section at its end.
+	// Together they describe the original document.
+	if n := len(revs); n >= 2 && revs[n-2].sec.offset < revs[n-1].sec.offset && r.linearized() {
+		revs[n-2] = revs[n-2].join(revs[n-1])
+		revs = revs[:n-1]
+	}
+	return revs, nil
 }
 
-// Trailer returns the file's Trailer value.
-func (r *Reader) Trailer() Value {
-	return Value{r, r.trailerptr, r.trailer}
+// linearized reports whether r's file begins with a linearization dictionary.
+func (r *Reader) linearized() bool {
+	def, ok := r.scanObject(0, false).(objdef)
+	if !ok {
+		return false
+	}
+	d, ok := def.obj.(dict)
+	return ok && d["Linearized"] != nil
+}
+
+// join returns the revision made of rev and the sections of main,
+// with the entries of rev taking precedence.
+func (rev xrefRevision) join(main xrefRevision) xrefRevision {
+	rev.sec = rev.sec.join(main.sec)
+	if main.xsec != nil {
+		if rev.xsec == nil {
+			rev.xsec = main.xsec
+		} else {
+			rev.xsec = rev.xsec.join(main.xsec)
+		}
+	}
+	return rev
+}
+
+// join returns a copy of sec with the entries of main added at the end.
+func (sec *xrefSection) join(main *xrefSection) *xrefSection {
+	sec1 := *sec
+	sec1.entries = append(append([]xrefEntry(nil), sec.entries...), main.entries...)
+	return &sec1
+}
+
+// mergeXref merges the cross-reference data of revs, given newest first,
+// into a single table. Newer revisions take precedence over older ones.
+// Objects freed by a revision are marked in the table
+// with the pointer {0, 65535}, hiding their older definitions.
+func mergeXref(r *Reader, revs []xrefRevision) ([]xref, error) {
+	var table []xref
+	add := func(sec *xrefSection, free bool) error {
+		for _, e := range sec.entries {
+			if e.free != free {
+				continue
+			}
+			x := int(e.x.ptr.id)
+			var err error
+			if table, err = r.growXref(table, x); err != nil {
+				return err
+			}
+			if table[x].ptr != (objptr{}) {
+				continue
+			}
+			if free {
+				table[x] = xref{ptr: objptr{0, 65535}}
+			} else {
+				table[x] = e.x
+			}
+		}
+		return nil
+	}
+	for _, rev := range revs {
+		// In a hybrid-reference file, the table marks the objects
+		// listed in the XRefStm stream as free, so the stream's
+		// entries must be merged before the table's free entries.
+		if err := add(rev.sec, false); err != nil {
+			return nil, err
+		}
+		if rev.xsec != nil {
+			if err := add(rev.xsec, false); err != nil {
+				return nil, err
+			}
+			if err := add(rev.xsec, true); err != nil {
+				return nil, err
+			}
+		}
+		if err := add(rev.sec, true); err != nil {
+			return nil, err
+		}
+	}
+
+	size, ok := revs[0].sec.trailer["Size"].(int64)
+	if !ok {
+		return nil, fmt.Errorf("malformed PDF: trailer missing /Size entry")
+	}
+	if size < int64(len(table)) {
+		table = table[:size]
+	}
+	return table, nil
 }
 
-func readXref(r *Reader, b *buffer) ([]xref, objptr, dict, error) {
+// readXrefSection reads the cross-reference section at offset off.
+func readXrefSection(r *Reader, off int64) (*xrefSection, error) {
+	b := newBuffer(io.NewSectionReader(r.f, off, r.end-off), off)
+	b.maxDepth = r.limits.MaxDepth
+	var sec *xrefSection
+	var err error
 	tok := b.readToken()
 	if tok == keyword("xref") {
-		return readXrefTable(r, b)
-	}
-	if _, ok := tok.(int64); ok {
+		sec, err = readXrefTable(r, b)
+	} else if _, ok := tok.(int64); ok {
 		b.unreadToken(tok)
-		return readXrefStream(r, b)
+		sec, err = readXrefStream(r, b)
+	} else {
+		return nil, fmt.Errorf("malformed PDF: cross-reference table not found at offset %d: %v", off, tok)
+	}
+	if err != nil {
+		return nil, err
 	}
-	return nil, objptr{}, nil, fmt.Errorf("malformed PDF: cross-reference table not found: %v", tok)
+	sec.offset = off
+	return sec, nil
 }
 
-func readXrefStream(r *Reader, b *buffer) ([]xref, objptr, dict, error) {
+func readXrefStream(r *Reader, b *buffer) (*xrefSection, error) {
 	obj1 := b.readObject()
 	obj, ok := obj1.(objdef)
 	if !ok {
-		return nil, objptr{}, nil, fmt.Errorf("malformed PDF: cross-reference table not found: %v", objfmt(obj1))
+		return nil, fmt.Errorf("malformed PDF: cross-reference table not found: %v", objfmt(obj1))
 	}
-	strmptr := obj.ptr
 	strm, ok := obj.obj.(stream)
 	if !ok {
-		return nil, objptr{}, nil, fmt.Errorf("malformed PDF: cross-reference table not found: %v", objfmt(obj))
+		return nil, fmt.Errorf("malformed PDF: cross-reference table not found: %v", objfmt(obj))
 	}
 	if strm.hdr["Type"] != name("XRef") {
-		return nil, objptr{}, nil, fmt.Errorf("malformed PDF: xref stream does not have type XRef")
+		return nil, fmt.Errorf("malformed PDF: xref stream does not have type XRef")
 	}
 	size, ok := strm.hdr["Size"].(int64)
 	if !ok {
-		return nil, objptr{}, nil, fmt.Errorf("malformed PDF: xref stream missing Size")
+		return nil, fmt.Errorf("malformed PDF: xref stream missing Size")
 	}
-	table := make([]xref, size)
-
-	table, err := readXrefStreamData(r, strm, table, size)
-	if err != nil {
-		return nil, objptr{}, nil, fmt.Errorf("malformed PDF: %v", err)
+	if err := r.checkObjects(size); err != nil {
+		return nil, err
 	}
-
-	for prevoff := strm.hdr["Prev"]; prevoff != nil; {
-		off, ok := prevoff.(int64)
-		if !ok {
-			return nil, objptr{}, nil, fmt.Errorf("malformed PDF: xref Prev is not integer: %v", prevoff)
-		}
-		b := newBuffer(io.NewSectionReader(r.f, off, r.end-off), off)
-		obj1 := b.readObject()
-		obj, ok := obj1.(objdef)
-		if !ok {
-			return nil, objptr{}, nil, fmt.Errorf("malformed PDF: xref prev stream not found: %v", objfmt(obj1))
-		}
-		prevstrm, ok := obj.obj.(stream)
-		if !ok {
-			return nil, objptr{}, nil, fmt.Errorf("malformed PDF: xref prev stream not found: %v", objfmt(obj))
-		}
-		prevoff = prevstrm.hdr["Prev"]
-		prev := Value{r, objptr{}, prevstrm}
-		if prev.Kind() != Stream {
-			return nil, objptr{}, nil, fmt.Errorf("malformed PDF: xref prev stream is not stream: %v", prev)
-		}
-		if prev.Key("Type").Name() != "XRef" {
-			return nil, objptr{}, nil, fmt.Errorf("malformed PDF: xref prev stream does not have type XRef")
-		}
-		psize := prev.Key("Size").Int64()
-		if psize > size {
-			return nil, objptr{}, nil, fmt.Errorf("malformed PDF: xref prev stream larger than last stream")
-		}
-		if table, err = readXrefStreamData(r, prev.data.(stream), table, psize); err != nil {
-			return nil, objptr{}, nil, fmt.Errorf("malformed PDF: reading xref prev stream: %v", err)
+	entries, err := readXrefStreamData(r, strm, size)
+	if err != nil {
+		if isLimit(err) {
+			return nil, err
 		}
+		return nil, fmt.Errorf("malformed PDF: %v", err)
 	}
-
-	return table, strmptr, strm.hdr, nil
+	return &xrefSection{ptr: obj.ptr, trailer: strm.hdr, entries: entries}, nil
 }
 
-func readXrefStreamData(r *Reader, strm stream, table []xref, size int64) ([]xref, error) {
+func readXrefStreamData(r *Reader, strm stream, size int64) ([]xrefEntry, error) {
 	index, _ := strm.hdr["Index"].(array)
 	if index == nil {
 		index = array{int64(0), size}
@@ -288,13 +797,18 @@ func readXrefStreamData(r *Reader, strm stream, table []xref, size int64) ([]xre
 		return nil, fmt.Errorf("invalid W array %v", objfmt(ww))
 	}
 
-	v := Value{r, objptr{}, strm}
+	v := Value{r, objptr{}, strm, nil, false}
 	wtotal := 0
 	for _, wid := range w {
 		wtotal += wid
 	}
 	buf := make([]byte, wtotal)
-	data := v.Reader()
+	rd, err := v.ReaderErr()
+	if err != nil {
+		return nil, err
+	}
+	data := r.limitBuffered(strm, rd)
+	var entries []xrefEntry
 	for len(index) > 0 {
 		start, ok1 := index[0].(int64)
 		n, ok2 := index[1].(int64)
@@ -302,9 +816,20 @@ func readXrefStreamData(r *Reader, strm stream, table []xref, size int64) ([]xre
 			return nil, fmt.Errorf("malformed Index pair %v %v %T %T", objfmt(index[0]), objfmt(index[1]), index[0], index[1])
 		}
 		index = index[2:]
+		if err := r.checkObjects(start + n); err != nil {
+			return nil, err
+		}
 		for i := 0; i < int(n); i++ {
+			if i%checkEvery == 0 {
+				if err := r.canceled(); err != nil {
+					return nil, err
+				}
+			}
 			_, err := io.ReadFull(data, buf)
 			if err != nil {
+				if isLimit(err) {
+					return nil, err
+				}
 				return nil, fmt.Errorf("error reading xref stream: %v", err)
 			}
 			v1 := decodeInt(buf[0:w[0]])
@@ -314,25 +839,36 @@ func readXrefStreamData(r *Reader, strm stream, table []xref, size int64) ([]xre
 			v2 := decodeInt(buf[w[0] : w[0]+w[1]])
 			v3 := decodeInt(buf[w[0]+w[1] : w[0]+w[1]+w[2]])
 			x := int(start) + i
-			for cap(table) <= x {
-				table = append(table[:cap(table)], xref{})
-			}
-			if table[x].ptr != (objptr{}) {
-				continue
-			}
 			switch v1 {
 			case 0:
-				table[x] = xref{ptr: objptr{0, 65535}}
+				entries = append(entries, xrefEntry{x: xref{ptr: objptr{uint32(x), uint16(v3)}}, free: true})
 			case 1:
-				table[x] = xref{ptr: objptr{uint32(x), uint16(v3)}, offset: int64(v2)}
+				entries = append(entries, xrefEntry{x: xref{ptr: objptr{uint32(x), uint16(v3)}, offset: int64(v2)}})
 			case 2:
-				table[x] = xref{ptr: objptr{uint32(x), 0}, inStream: true, stream: objptr{uint32(v2), 0}, offset: int64(v3)}
+				entries = append(entries, xrefEntry{x: xref{ptr: objptr{uint32(x), 0}, inStream: true, stream: objptr{uint32(v2), 0}, offset: int64(v3)}})
 			default:
-				fmt.Printf("invalid xref stream type %d: %x\n", v1, buf)
+				if r.strict {
+					return nil, fmt.Errorf("invalid xref stream type %d: %x", v1, buf)
+				}
+				r.warnf("xref", strm.ptr, -1, "ignoring invalid xref stream type %d: %x", v1, buf)
 			}
 		}
 	}
-	return table, nil
+	return entries, nil
+}
+
+// growXref returns table grown as needed to hold entry x.
+func (r *Reader) growXref(table []xref, x int) ([]xref, error) {
+	if x < len(table) {
+		return table, nil
+	}
+	if err := r.checkObjects(int64(x) + 1); err != nil {
+		return nil, err
+	}
+	for cap(table) <= x {
+		table = append(table[:cap(table)], xref{})
+	}
+	return table[:x+1], nil
 }
 
 func decodeInt(b []byte) int {
@@ -343,54 +879,24 @@ func decodeInt(b []byte) int {
 	return x
 }
 
-func readXrefTable(r *Reader, b *buffer) ([]xref, objptr, dict, error) {
-	var table []xref
-
-	table, err := readXrefTableData(b, table)
+func readXrefTable(r *Reader, b *buffer) (*xrefSection, error) {
+	entries, err := readXrefTableData(r, b)
 	if err != nil {
-		return nil, objptr{}, nil, fmt.Errorf("malformed PDF: %v", err)
-	}
-
-	trailer, ok := b.readObject().(dict)
-	if !ok {
-		return nil, objptr{}, nil, fmt.Errorf("malformed PDF: xref table not followed by trailer dictionary")
-	}
-
-	for prevoff := trailer["Prev"]; prevoff != nil; {
-		off, ok := prevoff.(int64)
-		if !ok {
-			return nil, objptr{}, nil, fmt.Errorf("malformed PDF: xref Prev is not integer: %v", prevoff)
-		}
-		b := newBuffer(io.NewSectionReader(r.f, off, r.end-off), off)
-		tok := b.readToken()
-		if tok != keyword("xref") {
-			return nil, objptr{}, nil, fmt.Errorf("malformed PDF: xref Prev does not point to xref")
-		}
-		table, err = readXrefTableData(b, table)
-		if err != nil {
-			return nil, objptr{}, nil, fmt.Errorf("malformed PDF: %v", err)
-		}
-
-		trailer, ok := b.readObject().(dict)
-		if !ok {
-			return nil, objptr{}, nil, fmt.Errorf("malformed PDF: xref Prev table not followed by trailer dictionary")
+		if isLimit(err) {
+			return nil, err
 		}
-		prevoff = trailer["Prev"]
+		return nil, fmt.Errorf("malformed PDF: %v", err)
 	}
 
-	size, ok := trailer[name("Size")].(int64)
+	trailer, ok := b.readObject().(dict)
 	if !ok {
-		return nil, objptr{}, nil, fmt.Errorf("malformed PDF: trailer missing /Size entry")
+		return nil, fmt.Errorf("malformed PDF: xref table not followed by trailer dictionary")
 	}
-
-	if size < int64(len(table)) {
-		table = table[:size]
-	}
-
-	return table, objptr{}, trailer, nil
+	return &xrefSection{trailer: trailer, entries: entries}, nil
 }
 
-func readXrefTableData(b *buffer, table []xref) ([]xref, error) {
+func readXrefTableData(r *Reader, b *buffer) ([]xrefEntry, error) {
+	var entries []xrefEntry
 	for {
 		tok := b.readToken()
 		if tok == keyword("trailer") {
@@ -401,7 +907,15 @@ func readXrefTableData(b *buffer, table []xref) ([]xref, error) {
 		if !ok1 || !ok2 {
 			return nil, fmt.Errorf("malformed xref table")
 		}
+		if err := r.checkObjects(start + n); err != nil {
+			return nil, err
+		}
 		for i := 0; i < int(n); i++ {
+			if i%checkEvery == 0 {
+				if err := r.canceled(); err != nil {
+					return nil, err
+				}
+			}
 			off, ok1 := b.readToken().(int64)
 			gen, ok2 := b.readToken().(int64)
 			alloc, ok3 := b.readToken().(keyword)
@@ -409,18 +923,14 @@ func readXrefTableData(b *buffer, table []xref) ([]xref, error) {
 				return nil, fmt.Errorf("malformed xref table")
 			}
 			x := int(start) + i
-			for cap(table) <= x {
-				table = append(table[:cap(table)], xref{})
-			}
-			if len(table) <= x {
-				table = table[:x+1]
-			}
-			if alloc == "n" && table[x].offset == 0 {
-				table[x] = xref{ptr: objptr{uint32(x), uint16(gen)}, offset: int64(off)}
+			e := xrefEntry{x: xref{ptr: objptr{uint32(x), uint16(gen)}}, free: alloc == "f"}
+			if !e.free {
+				e.x.offset = off
 			}
+			entries = append(entries, e)
 		}
 	}
-	return table, nil
+	return entries, nil
 }
 
 func findLastLine(buf []byte, s string) int {
@@ -442,8 +952,22 @@ func findLastLine(buf []byte, s string) int {
 // The zero Value is a PDF null (Kind() == Null, IsNull() = true).
 type Value struct {
 	r    *Reader
-	ptr  objptr
+	ptr  objptr // v itself if ref is set, otherwise the object containing v
 	data interface{}
+	err  error
+	ref  bool
+}
+
+// Ref returns the number and generation of the indirect object v,
+// and ok=true, if v was obtained by following an indirect reference
+// (such as 12 0 R) or from Resolve or ForEachObject.
+// Two such Values are the same object if their Refs are equal.
+// For a direct object, Ref returns ok=false.
+func (v Value) Ref() (id uint32, gen uint16, ok bool) {
+	if !v.ref {
+		return 0, 0, false
+	}
+	return v.ptr.id, v.ptr.gen, true
 }
 
 // IsNull reports whether the value is a null. It is equivalent to Kind() == Null.
@@ -467,6 +991,15 @@ const (
 	Stream
 )
 
+// Err returns the error, if any, encountered while loading v.
+// Values obtained from v by Key or Index inherit v's error,
+// so that after a chain like v.Key("Root").Key("Pages"), Err
+// reports the first problem along the way.
+// A Value with a non-nil Err is always a null.
+func (v Value) Err() error {
+	return v.err
+}
+
 // Kind reports the kind of value underlying v.
 func (v Value) Kind() ValueKind {
 	switch v.data.(type) {
@@ -657,7 +1190,7 @@ func (v Value) Key(key string) Value {
 	if !ok {
 		strm, ok := v.data.(stream)
 		if !ok {
-			return Value{}
+			return Value{err: v.err}
 		}
 		x = strm.hdr
 	}
@@ -690,7 +1223,7 @@ func (v Value) Keys() []string {
 func (v Value) Index(i int) Value {
 	x, ok := v.data.(array)
 	if !ok || i < 0 || i >= len(x) {
-		return Value{}
+		return Value{err: v.err}
 	}
 	return v.r.resolve(v.ptr, x[i])
 }
@@ -706,73 +1239,122 @@ func (v Value) Len() int {
 }
 
 func (r *Reader) resolve(parent objptr, x interface{}) Value {
+	ref := false
 	if ptr, ok := x.(objptr); ok {
-		if ptr.id >= uint32(len(r.xref)) {
-			return Value{}
-		}
-		xref := r.xref[ptr.id]
-		if xref.ptr != ptr || !xref.inStream && xref.offset == 0 {
-			return Value{}
-		}
-		var obj object
-		if xref.inStream {
-			strm := r.resolve(parent, xref.stream)
-		Search:
-			for {
-				if strm.Kind() != Stream {
-					panic("not a stream")
-				}
-				if strm.Key("Type").Name() != "ObjStm" {
-					panic("not an object stream")
-				}
-				n := int(strm.Key("N").Int64())
-				first := strm.Key("First").Int64()
-				if first == 0 {
-					panic("missing First")
-				}
-				b := newBuffer(strm.Reader(), 0)
-				b.allowEOF = true
-				for i := 0; i < n; i++ {
-					id, _ := b.readToken().(int64)
-					off, _ := b.readToken().(int64)
-					if uint32(id) == ptr.id {
-						b.seekForward(first + off)
-						x = b.readObject()
-						break Search
-					}
-				}
-				ext := strm.Key("Extends")
-				if ext.Kind() != Stream {
-					panic("cannot find object in stream")
-				}
-				strm = ext
-			}
-		} else {
-			b := newBuffer(io.NewSectionReader(r.f, xref.offset, r.end-xref.offset), xref.offset)
-			b.key = r.key
-			b.useAES = r.useAES
-			obj = b.readObject()
-			def, ok := obj.(objdef)
-			if !ok {
-				panic(fmt.Errorf("loading %v: found %T instead of objdef", ptr, obj))
-				return Value{}
-			}
-			if def.ptr != ptr {
-				panic(fmt.Errorf("loading %v: found %v", ptr, def.ptr))
-			}
-			x = def.obj
+		obj, err := r.load(ptr)
+		if err != nil {
+			r.report(err)
+			return Value{r, ptr, nil, err, true}
 		}
+		x = obj
 		parent = ptr
+		ref = true
 	}
 
 	switch x := x.(type) {
 	case nil, bool, int64, float64, name, dict, array, stream:
-		return Value{r, parent, x}
+		return Value{r, parent, x, nil, ref}
 	case string:
-		return Value{r, parent, x}
+		return Value{r, parent, x, nil, ref}
 	default:
-		panic(fmt.Errorf("unexpected value type %T in resolve", x))
+		err := &Error{ID: parent.id, Gen: parent.gen, Offset: -1, Err: fmt.Errorf("unexpected value type %T in resolve", x)}
+		r.report(err)
+		return Value{r, parent, nil, err, ref}
+	}
+}
+
+// Resolve returns the indirect object with the given number and generation.
+// A missing or free object is a null.
+func (r *Reader) Resolve(id uint32, gen uint16) Value {
+	return r.resolve(objptr{}, objptr{id, gen})
+}
+
+// NumObjects returns the size of the file's cross-reference table.
+// Object numbers run from 1 to NumObjects()-1,
+// though some numbers may be unused.
+func (r *Reader) NumObjects() int {
+	return len(r.xref)
+}
+
+// ForEachObject calls fn for each object in the file's cross-reference
+// table that is in use, in order of object number, stopping at the
+// first error returned by fn. An object that cannot be loaded is passed
+// to fn as a null Value whose Err method reports the problem.
+func (r *Reader) ForEachObject(fn func(id uint32, gen uint16, v Value) error) error {
+	for _, x := range r.xref {
+		if x.ptr == (objptr{}) || !x.inStream && x.offset == 0 {
+			continue
+		}
+		if err := fn(x.ptr.id, x.ptr.gen, r.resolve(objptr{}, x.ptr)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load returns the indirect object ptr, from the cache if possible.
+// A missing or free object loads as a null, without error.
+func (r *Reader) load(ptr objptr) (object, error) {
+	if r == nil || ptr.id >= uint32(len(r.xref)) {
+		return nil, nil
+	}
+	xref := r.xref[ptr.id]
+	if xref.ptr != ptr || !xref.inStream && xref.offset == 0 {
+		return nil, nil
+	}
+	if x, ok := r.cache.get(ptr); ok {
+		return x, nil
+	}
+	x, err := r.loadXref(ptr, xref)
+	if err != nil && !xref.inStream && !r.strict && !r.noScan {
+		if x1, ok := r.loadScanned(ptr, xref); ok {
+			x, err = x1, nil
+		}
 	}
+	if err != nil {
+		return nil, err
+	}
+	r.cache.add(ptr, x, 1)
+	return x, nil
+}
+
+// loadXref reads the indirect object ptr from the location given by xref.
+func (r *Reader) loadXref(ptr objptr, xref xref) (x object, err error) {
+	if xref.inStream {
+		defer catch(&err, ptr, -1)
+		w := r.newWalk("object stream chain")
+		for strm := xref.stream; ; {
+			if err := w.visit(strm); err != nil {
+				panic(err)
+			}
+			stm := r.objStm(strm)
+			if off, ok := stm.offsets[ptr.id]; ok {
+				b := newBuffer(bytes.NewReader(stm.data[off:]), off)
+				b.allowEOF = true
+				b.maxDepth = r.limits.MaxDepth
+				return b.readObject(), nil
+			}
+			if stm.extends == (objptr{}) {
+				r.errorf("cannot find object in stream")
+			}
+			strm = stm.extends
+		}
+	}
+
+	defer catch(&err, ptr, xref.offset)
+	b := newBuffer(io.NewSectionReader(r.f, xref.offset, r.end-xref.offset), xref.offset)
+	b.maxDepth = r.limits.MaxDepth
+	b.key = r.key
+	b.crypt = r.strCrypt
+	obj := b.readObject()
+	def, ok := obj.(objdef)
+	if !ok {
+		r.errorf("loading %v: found %T instead of objdef", ptr, obj)
+	}
+	if def.ptr != ptr {
+		r.errorf("loading %v: found %v", ptr, def.ptr)
+	}
+	return def.obj, nil
 }
 
 type errorReadCloser struct {
@@ -790,290 +1372,170 @@ func (e *errorReadCloser) Close() error {
 // Reader returns the data contained in the stream v.
 // If v.Kind() != Stream, Reader returns a ReadCloser that
 // responds to all reads with a ``stream not present'' error.
+// If the stream's filters cannot be applied, the ReadCloser responds
+// to all reads with the error that ReaderErr would have returned.
 func (v Value) Reader() io.ReadCloser {
-	x, ok := v.data.(stream)
-	if !ok {
-		return &errorReadCloser{fmt.Errorf("stream not present")}
-	}
-	var rd io.Reader
-	rd = io.NewSectionReader(v.r.f, x.offset, v.Key("Length").Int64())
-	if v.r.key != nil {
-		rd = decryptStream(v.r.key, v.r.useAES, x.ptr, rd)
-	}
-	filter := v.Key("Filter")
-	param := v.Key("DecodeParms")
-	switch filter.Kind() {
-	default:
-		panic(fmt.Errorf("unsupported filter %v", filter))
-	case Null:
-		// ok
-	case Name:
-		rd = applyFilter(rd, filter.Name(), param)
-	case Array:
-		for i := 0; i < filter.Len(); i++ {
-			rd = applyFilter(rd, filter.Index(i).Name(), param.Index(i))
+	rd, err := v.ReaderErr()
+	if err != nil {
+		if _, ok := v.data.(stream); ok {
+			v.r.report(err)
 		}
+		return &errorReadCloser{err}
 	}
+	return rd
+}
 
-	return ioutil.NopCloser(rd)
+// ReaderErr is like Reader but returns an error instead of a failing
+// ReadCloser when v is not a stream or its filters cannot be applied.
+// Errors in the encoded data itself are still reported by the
+// returned ReadCloser's Read method.
+func (v Value) ReaderErr() (io.ReadCloser, error) {
+	rd, _, err := v.decode(false)
+	return rd, err
 }
 
-func applyFilter(rd io.Reader, name string, param Value) io.Reader {
-	switch name {
-	default:
-		panic("unknown filter " + name)
-	case "FlateDecode":
-		zr, err := zlib.NewReader(rd)
-		if err != nil {
-			panic(err)
-		}
-		pred := param.Key("Predictor")
-		if pred.Kind() == Null {
-			return zr
-		}
-		columns := param.Key("Columns").Int64()
-		switch pred.Int64() {
-		default:
-			fmt.Println("unknown predictor", pred)
-			panic("pred")
-		case 12:
-			return &pngUpReader{r: zr, hist: make([]byte, 1+columns), tmp: make([]byte, 1+columns)}
-		}
+// ReaderContext is like ReaderErr but the returned ReadCloser's
+// Read method returns ctx.Err() once ctx is cancelled.
+func (v Value) ReaderContext(ctx context.Context) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rd, err := v.ReaderErr()
+	if err != nil {
+		return nil, err
 	}
+	return &ctxReadCloser{ctx, rd}, nil
 }
 
-type pngUpReader struct {
-	r    io.Reader
-	hist []byte
-	tmp  []byte
-	pend []byte
+// A ctxReadCloser checks for cancellation before each Read.
+type ctxReadCloser struct {
+	ctx context.Context
+	io.ReadCloser
 }
 
-func (r *pngUpReader) Read(b []byte) (int, error) {
-	n := 0
-	for len(b) > 0 {
-		if len(r.pend) > 0 {
-			m := copy(b, r.pend)
-			n += m
-			b = b[m:]
-			r.pend = r.pend[m:]
-			continue
-		}
-		_, err := io.ReadFull(r.r, r.tmp)
-		if err != nil {
-			return n, err
-		}
-		if r.tmp[0] != 2 {
-			return n, fmt.Errorf("malformed PNG-Up encoding")
-		}
-		for i, b := range r.tmp {
-			r.hist[i] += b
-		}
-		r.pend = r.hist[1:]
+func (c *ctxReadCloser) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.ReadCloser.Read(b)
+}
+
+// RawReader returns the data stored in the stream v, decrypted if the file
+// is encrypted but with none of the stream's filters applied.
+// If v.Kind() != Stream, RawReader returns an error.
+func (v Value) RawReader() (io.ReadCloser, error) {
+	rd, _, err := v.rawReader()
+	if err != nil {
+		return nil, err
 	}
-	return n, nil
+	return ioutil.NopCloser(rd), nil
 }
 
-var passwordPad = []byte{
-	0x28, 0xBF, 0x4E, 0x5E, 0x4E, 0x75, 0x8A, 0x41, 0x64, 0x00, 0x4E, 0x56, 0xFF, 0xFA, 0x01, 0x08,
-	0x2E, 0x2E, 0x00, 0xB6, 0xD0, 0x68, 0x3E, 0x80, 0x2F, 0x0C, 0xA9, 0xFE, 0x64, 0x53, 0x69, 0x7A,
+// PartialReader is like ReaderErr but applies the stream's filters only up to
+// the first one that this package cannot decode, such as DCTDecode (JPEG)
+// or JPXDecode (JPEG 2000). It returns the filters that remain to be applied
+// to the data, which is empty when the stream has been decoded completely.
+func (v Value) PartialReader() (io.ReadCloser, []Filter, error) {
+	return v.decode(true)
 }
 
-func (r *Reader) initEncrypt(password string) error {
-	// See PDF 32000-1:2008, §7.6.
-	encrypt, _ := r.resolve(objptr{}, r.trailer["Encrypt"]).data.(dict)
-	if encrypt["Filter"] != name("Standard") {
-		return fmt.Errorf("unsupported PDF: encryption filter %v", objfmt(encrypt["Filter"]))
-	}
-	n, _ := encrypt["Length"].(int64)
-	if n == 0 {
-		n = 40
-	}
-	if n%8 != 0 || n > 128 || n < 40 {
-		return fmt.Errorf("malformed PDF: %d-bit encryption key", n)
-	}
-	V, _ := encrypt["V"].(int64)
-	if V != 1 && V != 2 && (V != 4 || !okayV4(encrypt)) {
-		return fmt.Errorf("unsupported PDF: encryption version V=%d; %v", V, objfmt(encrypt))
-	}
+// A Filter is a single step in the filter chain of a stream.
+type Filter struct {
+	Name  string // the filter name, such as "FlateDecode" or "DCTDecode"
+	Param Value  // the filter's decoding parameters, if any
+}
 
-	ids, ok := r.trailer["ID"].(array)
-	if !ok || len(ids) < 1 {
-		return fmt.Errorf("malformed PDF: missing ID in trailer")
-	}
-	idstr, ok := ids[0].(string)
+// Filters returns the filters to be applied to decode the stream v,
+// in the order they must be applied.
+// A Crypt filter is part of decryption and is not included.
+// If v.Kind() != Stream or v has no filters, Filters returns nil.
+func (v Value) Filters() []Filter {
+	x, ok := v.data.(stream)
 	if !ok {
-		return fmt.Errorf("malformed PDF: missing ID in trailer")
-	}
-	ID := []byte(idstr)
-
-	R, _ := encrypt["R"].(int64)
-	if R < 2 {
-		return fmt.Errorf("malformed PDF: encryption revision R=%d", R)
-	}
-	if R > 4 {
-		return fmt.Errorf("unsupported PDF: encryption revision R=%d", R)
+		return nil
 	}
-	O, _ := encrypt["O"].(string)
-	U, _ := encrypt["U"].(string)
-	if len(O) != 32 || len(U) != 32 {
-		return fmt.Errorf("malformed PDF: missing O= or U= encryption parameters")
+	filters, _ := v.filters(x)
+	if len(filters) > 0 && filters[0].Name == "Crypt" {
+		filters = filters[1:]
 	}
-	p, _ := encrypt["P"].(int64)
-	P := uint32(p)
+	return filters
+}
 
-	// TODO: Password should be converted to Latin-1.
-	pw := []byte(password)
-	h := md5.New()
-	if len(pw) >= 32 {
-		h.Write(pw[:32])
-	} else {
-		h.Write(pw)
-		h.Write(passwordPad[:32-len(pw)])
-	}
-	h.Write([]byte(O))
-	h.Write([]byte{byte(P), byte(P >> 8), byte(P >> 16), byte(P >> 24)})
-	h.Write([]byte(ID))
-	key := h.Sum(nil)
-
-	if R >= 3 {
-		for i := 0; i < 50; i++ {
-			h.Reset()
-			h.Write(key[:n/8])
-			key = h.Sum(key[:0])
+func (v Value) filters(x stream) ([]Filter, error) {
+	filter := v.Key("Filter")
+	param := v.Key("DecodeParms")
+	switch filter.Kind() {
+	default:
+		return nil, x.errorf("unsupported filter %v", filter)
+	case Null:
+		return nil, nil
+	case Name:
+		if param.Kind() == Array {
+			param = param.Index(0)
 		}
-		key = key[:n/8]
-	} else {
-		key = key[:40/8]
-	}
-
-	c, err := rc4.NewCipher(key)
-	if err != nil {
-		return fmt.Errorf("malformed PDF: invalid RC4 key: %v", err)
-	}
-
-	var u []byte
-	if R == 2 {
-		u = make([]byte, 32)
-		copy(u, passwordPad)
-		c.XORKeyStream(u, u)
-	} else {
-		h.Reset()
-		h.Write(passwordPad)
-		h.Write([]byte(ID))
-		u = h.Sum(nil)
-		c.XORKeyStream(u, u)
-
-		for i := 1; i <= 19; i++ {
-			key1 := make([]byte, len(key))
-			copy(key1, key)
-			for j := range key1 {
-				key1[j] ^= byte(i)
-			}
-			c, _ = rc4.NewCipher(key1)
-			c.XORKeyStream(u, u)
+		return []Filter{{filter.Name(), param}}, nil
+	case Array:
+		var filters []Filter
+		for i := 0; i < filter.Len(); i++ {
+			filters = append(filters, Filter{filter.Index(i).Name(), param.Index(i)})
 		}
+		return filters, nil
 	}
-
-	if !bytes.HasPrefix([]byte(U), u) {
-		return ErrInvalidPassword
-	}
-
-	r.key = key
-	r.useAES = V == 4
-
-	return nil
 }
 
-var ErrInvalidPassword = fmt.Errorf("encrypted PDF: invalid password")
-
-func okayV4(encrypt dict) bool {
-	cf, ok := encrypt["CF"].(dict)
-	if !ok {
-		return false
-	}
-	stmf, ok := encrypt["StmF"].(name)
-	if !ok {
-		return false
-	}
-	strf, ok := encrypt["StrF"].(name)
+// rawReader returns a reader for the stored data of the stream v,
+// after decryption, along with the filters that remain to be applied.
+func (v Value) rawReader() (io.Reader, []Filter, error) {
+	x, ok := v.data.(stream)
 	if !ok {
-		return false
-	}
-	if stmf != strf {
-		return false
-	}
-	cfparam, ok := cf[stmf].(dict)
-	if cfparam["AuthEvent"] != nil && cfparam["AuthEvent"] != name("DocOpen") {
-		return false
+		if v.err != nil {
+			return nil, nil, v.err
+		}
+		return nil, nil, fmt.Errorf("stream not present")
 	}
-	if cfparam["Length"] != nil && cfparam["Length"] != int64(16) {
-		return false
+	filters, err := v.filters(x)
+	if err != nil {
+		return nil, nil, err
 	}
-	if cfparam["CFM"] != name("AESV2") {
-		return false
+	var rd io.Reader
+	rd = io.NewSectionReader(v.r.f, x.offset, v.Key("Length").Int64())
+	if v.r.key != nil {
+		crypt, err := v.r.streamCrypt(v, filters)
+		if err != nil {
+			return nil, nil, x.errorf("%v", err)
+		}
+		rd, err = decryptStream(v.r.key, crypt, x.ptr, rd)
+		if err != nil {
+			return nil, nil, x.errorf("%v", err)
+		}
 	}
-	return true
-}
-
-func cryptKey(key []byte, useAES bool, ptr objptr) []byte {
-	h := md5.New()
-	h.Write(key)
-	h.Write([]byte{byte(ptr.id), byte(ptr.id >> 8), byte(ptr.id >> 16), byte(ptr.gen), byte(ptr.gen >> 8)})
-	if useAES {
-		h.Write([]byte("sAlT"))
+	if len(filters) > 0 && filters[0].Name == "Crypt" {
+		filters = filters[1:]
 	}
-	return h.Sum(nil)
+	return rd, filters, nil
 }
 
-func decryptString(key []byte, useAES bool, ptr objptr, x string) string {
-	key = cryptKey(key, useAES, ptr)
-	if useAES {
-		panic("AES not implemented")
-	} else {
-		c, _ := rc4.NewCipher(key)
-		data := []byte(x)
-		c.XORKeyStream(data, data)
-		x = string(data)
+// decode returns a reader for the decoded data of the stream v.
+// If partial is set, decoding stops before the first filter that
+// cannot be decoded, and decode returns the remaining filters.
+func (v Value) decode(partial bool) (io.ReadCloser, []Filter, error) {
+	rd, filters, err := v.rawReader()
+	if err != nil {
+		return nil, nil, err
 	}
-	return x
-}
-
-func decryptStream(key []byte, useAES bool, ptr objptr, rd io.Reader) io.Reader {
-	key = cryptKey(key, useAES, ptr)
-	if useAES {
-		cb, err := aes.NewCipher(key)
+	for i, f := range filters {
+		if partial && !canDecode(f.Name) {
+			return ioutil.NopCloser(v.r.limitStream(v.data.(stream), rd)), filters[i:], nil
+		}
+		rd, err = applyFilter(rd, f.Name, f.Param, v.r.limits.MaxStreamSize)
 		if err != nil {
-			panic("AES: " + err.Error())
+			return nil, nil, v.data.(stream).errorf("%w", err)
 		}
-		iv := make([]byte, 16)
-		io.ReadFull(rd, iv)
-		cbc := cipher.NewCBCDecrypter(cb, iv)
-		rd = &cbcReader{cbc: cbc, rd: rd, buf: make([]byte, 16)}
-	} else {
-		c, _ := rc4.NewCipher(key)
-		rd = &cipher.StreamReader{c, rd}
 	}
-	return rd
-}
-
-type cbcReader struct {
-	cbc  cipher.BlockMode
-	rd   io.Reader
-	buf  []byte
-	pend []byte
+	return ioutil.NopCloser(v.r.limitStream(v.data.(stream), rd)), nil, nil
 }
 
-func (r *cbcReader) Read(b []byte) (n int, err error) {
-	if len(r.pend) == 0 {
-		_, err = io.ReadFull(r.rd, r.buf)
-		if err != nil {
-			return 0, err
-		}
-		r.cbc.CryptBlocks(r.buf, r.buf)
-		r.pend = r.buf
-	}
-	n = copy(b, r.pend)
-	r.pend = r.pend[n:]
-	return n, nil
+// errorf returns an *Error locating a problem with the stream x.
+func (x stream) errorf(format string, args ...interface{}) error {
+	return &Error{ID: x.ptr.id, Gen: x.ptr.gen, Offset: x.offset, Err: fmt.Errorf(format, args...)}
 }
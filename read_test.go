@@ -0,0 +1,218 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// A testFile builds a PDF file for a test.
+type testFile struct {
+	bytes.Buffer
+}
+
+func newTestFile() *testFile {
+	f := new(testFile)
+	f.WriteString("%PDF-1.5\n")
+	return f
+}
+
+// obj writes object id, with the given body, and returns its offset.
+func (f *testFile) obj(id int, body string) int64 {
+	off := int64(f.Len())
+	fmt.Fprintf(f, "%d 0 obj\n%s\nendobj\n", id, body)
+	return off
+}
+
+// stream writes object id as a stream with the given dictionary entries
+// and data, and returns its offset.
+func (f *testFile) stream(id int, dict, data string) int64 {
+	return f.obj(id, fmt.Sprintf("<<%s /Length %d>>\nstream\n%s\nendstream", dict, len(data), data))
+}
+
+// objStm writes object id as an object stream holding
+// the objects with the given numbers and bodies.
+func (f *testFile) objStm(id int, ids []int, bodies []string) int64 {
+	var index, data string
+	for i, body := range bodies {
+		index += fmt.Sprintf("%d %d ", ids[i], len(data))
+		data += body + " "
+	}
+	return f.stream(id, fmt.Sprintf("/Type /ObjStm /N %d /First %d", len(ids), len(index)), index+data)
+}
+
+// An xentry is a cross-reference entry: typ 0 is a free object,
+// typ 1 an object at offset f2 with generation f3, and
+// typ 2 the object with index f3 in the object stream f2.
+type xentry struct {
+	id  int
+	typ int
+	f2  int64
+	f3  int
+}
+
+// table writes a cross-reference table holding entries,
+// followed by a trailer with the given entries, and returns its offset.
+func (f *testFile) table(entries []xentry, trailer string) int64 {
+	off := int64(f.Len())
+	f.WriteString("xref\n")
+	for _, e := range entries {
+		c := 'n'
+		if e.typ == 0 {
+			c = 'f'
+		}
+		fmt.Fprintf(f, "%d 1\n%010d %05d %c \n", e.id, e.f2, e.f3, c)
+	}
+	fmt.Fprintf(f, "trailer\n<<%s>>\n", trailer)
+	return off
+}
+
+// xrefStream writes object id as a cross-reference stream
+// holding entries, with the given dictionary entries,
+// and returns its offset.
+func (f *testFile) xrefStream(id int, entries []xentry, dict string) int64 {
+	var index, data string
+	for _, e := range entries {
+		index += fmt.Sprintf("%d 1 ", e.id)
+		data += string([]byte{byte(e.typ), byte(e.f2 >> 24), byte(e.f2 >> 16), byte(e.f2 >> 8), byte(e.f2), byte(e.f3 >> 8), byte(e.f3)})
+	}
+	return f.stream(id, fmt.Sprintf("/Type /XRef /W [1 4 2] /Index [%s]%s", index, dict), data)
+}
+
+// end writes the startxref line and returns the file's data.
+func (f *testFile) end(startxref int64) []byte {
+	fmt.Fprintf(f, "startxref\n%d\n%%%%EOF\n", startxref)
+	return f.Bytes()
+}
+
+func openTest(t *testing.T, data []byte, opts *ReaderOptions) *Reader {
+	r, err := NewReaderOptions(bytes.NewReader(data), int64(len(data)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// checkObjects checks that each object in want resolves to the given string.
+func checkObjects(t *testing.T, r *Reader, want map[uint32]string) {
+	t.Helper()
+	for id, s := range want {
+		v := r.Resolve(id, 0)
+		if got := v.RawString(); got != s || v.Err() != nil {
+			t.Errorf("object %d = %q (err %v), want %q", id, got, v.Err(), s)
+		}
+	}
+}
+
+const testCatalog = "<< /Type /Catalog /Pages 2 0 R >>"
+
+func TestHybridXref(t *testing.T) {
+	f := newTestFile()
+	o1 := f.obj(1, testCatalog)
+	o2 := f.obj(2, "<< /Type /Pages /Kids [] /Count 0 >>")
+	o3 := f.obj(3, "(old 3)")
+	o4a := f.obj(4, "(table 4)")
+	o4b := f.obj(4, "(stream 4)")
+	o6 := f.objStm(6, []int{5}, []string{"(compressed 5)"})
+	xs := f.xrefStream(7, []xentry{
+		{4, 1, o4b, 0},
+		{5, 2, 6, 0},
+		{6, 1, o6, 0},
+	}, " /Size 8")
+	// The table lists 5 as free, for readers that do not know XRefStm,
+	// and 4 as in use: the table's in-use entry takes precedence.
+	start := f.table([]xentry{
+		{0, 0, 0, 65535},
+		{1, 1, o1, 0},
+		{2, 1, o2, 0},
+		{3, 1, o3, 0},
+		{4, 1, o4a, 0},
+		{5, 0, 0, 0},
+	}, fmt.Sprintf(" /Size 8 /Root 1 0 R /XRefStm %d", xs))
+	r := openTest(t, f.end(start), &ReaderOptions{Strict: true})
+	checkObjects(t, r, map[uint32]string{3: "old 3", 4: "table 4", 5: "compressed 5"})
+}
+
+func TestMixedXrefChain(t *testing.T) {
+	// The original file has a table; the first update a stream,
+	// and the second update a table again.
+	f := newTestFile()
+	o1 := f.obj(1, testCatalog)
+	o2 := f.obj(2, "<< /Type /Pages /Kids [] /Count 0 >>")
+	o3 := f.obj(3, "(original 3)")
+	o4 := f.obj(4, "(original 4)")
+	o5 := f.obj(5, "(original 5)")
+	t0 := f.table([]xentry{
+		{0, 0, 0, 65535},
+		{1, 1, o1, 0},
+		{2, 1, o2, 0},
+		{3, 1, o3, 0},
+		{4, 1, o4, 0},
+		{5, 1, o5, 0},
+	}, " /Size 6 /Root 1 0 R")
+
+	o3 = f.obj(3, "(update 1 3)")
+	o6 := f.objStm(6, []int{4}, []string{"(update 1 4)"})
+	s1 := int64(f.Len())
+	f.xrefStream(7, []xentry{
+		{3, 1, o3, 0},
+		{4, 2, 6, 0},
+		{5, 0, 0, 1},
+		{6, 1, o6, 0},
+		{7, 1, s1, 0},
+	}, fmt.Sprintf(" /Size 8 /Root 1 0 R /Prev %d", t0))
+
+	o4 = f.obj(4, "(update 2 4)")
+	t2 := f.table([]xentry{
+		{4, 1, o4, 0},
+	}, fmt.Sprintf(" /Size 8 /Root 1 0 R /Prev %d", s1))
+
+	r := openTest(t, f.end(t2), &ReaderOptions{Strict: true})
+	checkObjects(t, r, map[uint32]string{3: "update 1 3", 4: "update 2 4"})
+	if v := r.Resolve(5, 0); !v.IsNull() {
+		t.Errorf("freed object 5 = %v, want null", v)
+	}
+	if n := len(r.Revisions()); n != 3 {
+		t.Errorf("len(Revisions()) = %d, want 3", n)
+	}
+	r1, err := r.AtRevision(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkObjects(t, r1, map[uint32]string{3: "update 1 3", 4: "update 1 4"})
+	r0, err := r.AtRevision(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkObjects(t, r0, map[uint32]string{3: "original 3", 4: "original 4", 5: "original 5"})
+}
+
+func TestXrefPrevLoop(t *testing.T) {
+	f := newTestFile()
+	o1 := f.obj(1, testCatalog)
+	o2 := f.obj(2, "<< /Type /Pages /Kids [] /Count 0 >>")
+	o3 := f.obj(3, "(three)")
+	start := int64(f.Len())
+	f.table([]xentry{
+		{0, 0, 0, 65535},
+		{1, 1, o1, 0},
+		{2, 1, o2, 0},
+		{3, 1, o3, 0},
+	}, fmt.Sprintf(" /Size 4 /Root 1 0 R /Prev %d", start))
+	data := f.end(start)
+
+	_, err := NewReaderOptions(bytes.NewReader(data), int64(len(data)), &ReaderOptions{Strict: true})
+	if err == nil || !strings.Contains(err.Error(), "Prev loop") {
+		t.Errorf("strict open: err = %v, want Prev loop", err)
+	}
+	r := openTest(t, data, nil)
+	if !r.Repaired() {
+		t.Errorf("Repaired() = false, want true")
+	}
+	checkObjects(t, r, map[uint32]string{3: "three"})
+}
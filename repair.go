@@ -0,0 +1,289 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Recovery of damaged cross-reference tables by scanning the file.
+
+package pdf
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"sync/atomic"
+)
+
+// A scanResult records the objects and trailers found by scanning a file.
+type scanResult struct {
+	table   []xref   // direct objects, by number; the last definition wins
+	objStms []objptr // object streams
+	trailer dict     // Root, Info, ID, and Encrypt entries of the trailers found
+}
+
+var (
+	scanObjRE  = regexp.MustCompile(`(\d{1,10})[\x00\t\n\f\r ]+(\d{1,5})[\x00\t\n\f\r ]+obj\b`)
+	scanMarkRE = regexp.MustCompile(`trailer\b|/Type[\x00\t\n\f\r ]*/(XRef|ObjStm|Catalog)\b`)
+)
+
+// A scanMark is a keyword of interest found while scanning.
+type scanMark struct {
+	kind   string // "trailer", "XRef", "ObjStm", or "Catalog"
+	offset int64
+}
+
+// scanFile scans the entire file for object definitions,
+// trailer dictionaries, and cross-reference stream dictionaries.
+func scanFile(r *Reader) *scanResult {
+	const (
+		chunk   = 1 << 20
+		overlap = 64
+	)
+	type objAt struct {
+		ptr    objptr
+		offset int64
+	}
+	var objs []objAt
+	var marks []scanMark
+	buf := make([]byte, overlap+chunk+overlap)
+	for base := int64(0); base < r.end; base += chunk {
+		if r.canceled() != nil {
+			break // the caller reports the cancellation
+		}
+		// Read overlap bytes on each side of the chunk,
+		// so that matches crossing its boundaries are found
+		// and the byte before each match can be checked.
+		start := base - overlap
+		if start < 0 {
+			start = 0
+		}
+		n, _ := r.f.ReadAt(buf[:base+chunk+overlap-start], start)
+		data := buf[:n]
+		inChunk := func(i int) bool {
+			off := start + int64(i)
+			return base <= off && off < base+chunk && (i == 0 || !isDigit(data[i-1]))
+		}
+		for _, m := range scanObjRE.FindAllSubmatchIndex(data, -1) {
+			if !inChunk(m[0]) {
+				continue
+			}
+			id, _ := parseDecimal(data[m[2]:m[3]])
+			gen, _ := parseDecimal(data[m[4]:m[5]])
+			if id == 0 || id != int64(uint32(id)) || gen != int64(uint16(gen)) {
+				continue
+			}
+			objs = append(objs, objAt{objptr{uint32(id), uint16(gen)}, start + int64(m[0])})
+		}
+		for _, m := range scanMarkRE.FindAllSubmatchIndex(data, -1) {
+			if !inChunk(m[0]) {
+				continue
+			}
+			kind := "trailer"
+			if m[2] >= 0 {
+				kind = string(data[m[2]:m[3]])
+			}
+			marks = append(marks, scanMark{kind, start + int64(m[0])})
+		}
+	}
+
+	// Drop objects numbered beyond Limits.MaxObjects,
+	// which could otherwise make the table arbitrarily large.
+	max := -1
+	skipped := 0
+	keep := objs[:0]
+	for _, o := range objs {
+		if r.checkObjects(int64(o.ptr.id)+1) != nil {
+			skipped++
+			continue
+		}
+		keep = append(keep, o)
+		if int(o.ptr.id) > max {
+			max = int(o.ptr.id)
+		}
+	}
+	objs = keep
+	if skipped > 0 {
+		r.warnf("xref", objptr{}, -1, "ignoring %d objects numbered beyond MaxObjects=%d", skipped, r.limits.MaxObjects)
+	}
+
+	s := new(scanResult)
+	s.table = make([]xref, max+1)
+	for _, o := range objs {
+		s.table[o.ptr.id] = xref{ptr: o.ptr, offset: o.offset}
+	}
+
+	// containing returns the object whose definition contains offset.
+	containing := func(offset int64) (objAt, bool) {
+		i := sort.Search(len(objs), func(i int) bool { return objs[i].offset > offset })
+		if i == 0 {
+			return objAt{}, false
+		}
+		return objs[i-1], true
+	}
+
+	s.trailer = make(dict)
+	var catalog objptr
+	for _, m := range marks {
+		if m.kind == "trailer" {
+			if t, ok := r.scanObject(m.offset, true).(dict); ok {
+				s.addTrailer(t)
+			}
+			continue
+		}
+		o, ok := containing(m.offset)
+		if !ok || s.table[o.ptr.id].offset != o.offset {
+			continue
+		}
+		def, ok := r.scanObject(o.offset, false).(objdef)
+		if !ok {
+			continue
+		}
+		switch m.kind {
+		case "XRef":
+			if strm, ok := def.obj.(stream); ok && strm.hdr["Type"] == name("XRef") {
+				s.addTrailer(strm.hdr)
+			}
+		case "ObjStm":
+			if strm, ok := def.obj.(stream); ok && strm.hdr["Type"] == name("ObjStm") {
+				s.objStms = append(s.objStms, o.ptr)
+			}
+		case "Catalog":
+			if d, ok := def.obj.(dict); ok && d["Type"] == name("Catalog") {
+				catalog = o.ptr
+			}
+		}
+	}
+	if s.trailer["Root"] == nil && catalog != (objptr{}) {
+		s.trailer["Root"] = catalog
+	}
+	s.trailer["Size"] = int64(len(s.table))
+	return s
+}
+
+// addTrailer merges the document-level entries of the trailer t into s.trailer.
+func (s *scanResult) addTrailer(t dict) {
+	for _, key := range []name{"Root", "Info", "ID", "Encrypt"} {
+		if v, ok := t[key]; ok {
+			s.trailer[key] = v
+		}
+	}
+}
+
+// scanObject parses the object found at offset, returning nil if there is none.
+// If trailer is set, the object is expected to follow a trailer keyword.
+func (r *Reader) scanObject(offset int64, trailer bool) (obj object) {
+	defer func() {
+		if recover() != nil {
+			obj = nil
+		}
+	}()
+	b := newBuffer(io.NewSectionReader(r.f, offset, r.end-offset), offset)
+	b.maxDepth = r.limits.MaxDepth
+	if trailer && b.readToken() != keyword("trailer") {
+		return nil
+	}
+	return b.readObject()
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func parseDecimal(b []byte) (int64, bool) {
+	var x int64
+	for _, c := range b {
+		if !isDigit(c) || x > (1<<62)/10 {
+			return 0, false
+		}
+		x = x*10 + int64(c-'0')
+	}
+	return x, true
+}
+
+func (r *Reader) scanned() *scanResult {
+	r.scanOnce.Do(func() {
+		r.scan = scanFile(r)
+	})
+	return r.scan
+}
+
+// rebuild replaces r's cross-reference table and trailer
+// with those recovered by scanning the file.
+// Objects in object streams are added by addScannedObjStms,
+// once any decryption has been set up.
+func (r *Reader) rebuild() error {
+	s := r.scanned()
+	if s.trailer["Root"] == nil {
+		return fmt.Errorf("malformed PDF: cannot find document catalog")
+	}
+	r.xref = append([]xref(nil), s.table...)
+	r.trailer = s.trailer
+	r.trailerptr = objptr{}
+	r.revs = nil
+	r.rebuilt = true
+	atomic.StoreUint32(&r.repaired, 1)
+	return nil
+}
+
+// addScannedObjStms adds to r's cross-reference table the objects
+// stored in the object streams found by scanning the file.
+// Objects defined directly in the file take precedence.
+func (r *Reader) addScannedObjStms() {
+	for _, ptr := range r.scanned().objStms {
+		func() {
+			defer func() {
+				if e := recover(); e != nil {
+					r.warnf("xref", ptr, -1, "ignoring object stream: %v", toError(e, ptr, -1))
+				}
+			}()
+			stm := r.objStm(ptr)
+			max, skipped := -1, 0
+			for id := range stm.offsets {
+				if r.checkObjects(int64(id)+1) != nil {
+					skipped++
+					continue
+				}
+				if int(id) > max {
+					max = int(id)
+				}
+			}
+			if skipped > 0 {
+				r.warnf("xref", ptr, -1, "ignoring %d objects numbered beyond MaxObjects=%d", skipped, r.limits.MaxObjects)
+			}
+			if max >= len(r.xref) {
+				r.xref = append(r.xref, make([]xref, max+1-len(r.xref))...)
+			}
+			for id := range stm.offsets {
+				if int(id) <= max && r.xref[id].ptr == (objptr{}) {
+					r.xref[id] = xref{ptr: objptr{id, 0}, inStream: true, stream: ptr}
+				}
+			}
+		}()
+	}
+}
+
+// loadScanned loads the object ptr, whose cross-reference entry
+// did not lead to it, from the location found by scanning the file.
+func (r *Reader) loadScanned(ptr objptr, bad xref) (object, bool) {
+	s := r.scanned()
+	if ptr.id >= uint32(len(s.table)) {
+		return nil, false
+	}
+	xref := s.table[ptr.id]
+	if xref.ptr != ptr || xref.offset == bad.offset {
+		return nil, false
+	}
+	x, err := r.loadXref(ptr, xref)
+	if err != nil {
+		return nil, false
+	}
+	atomic.StoreUint32(&r.repaired, 1)
+	r.warnf("xref", ptr, xref.offset, "cross-reference entry points to offset %d, found object at %d", bad.offset, xref.offset)
+	return x, true
+}
+
+// Repaired reports whether r had to work around a damaged
+// cross-reference table, by scanning the file for object definitions.
+func (r *Reader) Repaired() bool {
+	return atomic.LoadUint32(&r.repaired) != 0
+}
@@ -0,0 +1,106 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Access to the revisions created by incremental updates.
+
+package pdf
+
+import "fmt"
+
+// A Revision describes one revision of a PDF file:
+// either the original document or one of the incremental updates
+// appended to it. Each revision ends with its own cross-reference
+// section and trailer.
+type Revision struct {
+	Offset  int64    // offset of the revision's cross-reference section, as given by startxref
+	Trailer Value    // the revision's trailer, or the dictionary of its cross-reference stream
+	Added   []uint32 // objects defined by this revision that were not in use before
+	Changed []uint32 // objects in use before and redefined by this revision
+	Freed   []uint32 // objects in use before and freed by this revision
+}
+
+// Revisions returns the revisions of the file, oldest first.
+// A file that has never been updated has a single revision.
+// If r had to rebuild the file's cross-reference table (see Repaired),
+// the revision history is unknown and Revisions returns nil.
+func (r *Reader) Revisions() []Revision {
+	var out []Revision
+	live := make(map[uint32]xref)
+	for i := len(r.revs) - 1; i >= 0; i-- {
+		rev := r.revs[i]
+		table, _ := mergeXref(r, r.revs[i:i+1])
+		out = append(out, Revision{
+			Offset:  rev.sec.offset,
+			Trailer: Value{r, rev.sec.ptr, rev.sec.trailer, nil, false},
+		})
+		last := &out[len(out)-1]
+		for x, e := range table {
+			id := uint32(x)
+			if id == 0 || e.ptr == (objptr{}) {
+				continue
+			}
+			old, inUse := live[id]
+			if e.ptr.id != id {
+				// freed
+				if inUse {
+					last.Freed = append(last.Freed, id)
+					delete(live, id)
+				}
+				continue
+			}
+			switch {
+			case !inUse:
+				last.Added = append(last.Added, id)
+			case old != e:
+				last.Changed = append(last.Changed, id)
+			}
+			live[id] = e
+		}
+	}
+	return out
+}
+
+// AtRevision returns a Reader for the document as it was at revision i,
+// as numbered by Revisions, ignoring the updates made by later revisions.
+// The returned Reader shares r's underlying data and decryption key.
+// Closing it does not close r's file.
+// Unlike r, the returned Reader does not fall back to scanning the file
+// for an object whose cross-reference entry is damaged, since the scan
+// could find the object as redefined by a later revision.
+func (r *Reader) AtRevision(i int) (*Reader, error) {
+	if i < 0 || i >= len(r.revs) {
+		return nil, fmt.Errorf("revision %d out of range", i)
+	}
+	revs := r.revs[len(r.revs)-1-i:]
+	xref, err := mergeXref(r, revs)
+	if err != nil {
+		return nil, err
+	}
+	r1 := &Reader{
+		f:          r.f,
+		end:        r.end,
+		version:    r.version,
+		xref:       xref,
+		trailer:    revs[0].sec.trailer,
+		trailerptr: revs[0].sec.ptr,
+		revs:       revs,
+		key:        r.key,
+		stmCrypt:   r.stmCrypt,
+		strCrypt:   r.strCrypt,
+		errh:       r.errh,
+		strict:     r.strict,
+		logger:     r.logger,
+		warnh:      r.warnh,
+		limits:     r.limits,
+		noScan:     true,
+
+		cryptFilters:    r.cryptFilters,
+		encryptMetadata: r.encryptMetadata,
+		perms:           r.perms,
+		passwordKind:    r.passwordKind,
+		cache:           newObjCache(r.cache.size()),
+		stmCache:        newObjCache(r.stmCache.size()),
+	}
+	return r1, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdf
+
+import (
+	"fmt"
+	"testing"
+)
+
+// linearizedFile returns a file with the layout of a linearized file:
+// the linearization dictionary, the first-page cross-reference section,
+// the first page's objects, the other objects, and the main section.
+// If update is set, an incremental update redefining object 5 follows.
+func linearizedFile(update bool) []byte {
+	var off [8]int64
+	var main int64
+	var f *testFile
+	// The sections come before the objects they list, so build the file
+	// twice: the second time with the offsets found the first time.
+	// Offsets are written with a fixed width, so they do not move.
+	for pass := 0; pass < 2; pass++ {
+		f = newTestFile()
+		off[7] = f.obj(7, "<< /Linearized 1 /L 0000000000 /O 3 /N 1 >>")
+		first := f.table([]xentry{
+			{1, 1, off[1], 0},
+			{3, 1, off[3], 0},
+			{4, 1, off[4], 0},
+			{7, 1, off[7], 0},
+		}, fmt.Sprintf(" /Size 8 /Root 1 0 R /Prev %010d", main))
+		off[1] = f.obj(1, "<< /Type /Catalog /Pages 2 0 R >>")
+		off[3] = f.obj(3, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R >>")
+		off[4] = f.stream(4, "", "BT ET")
+		off[2] = f.obj(2, "<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+		off[5] = f.obj(5, "(original 5)")
+		main = f.table([]xentry{
+			{0, 0, 0, 65535},
+			{2, 1, off[2], 0},
+			{5, 1, off[5], 0},
+		}, " /Size 8")
+		fmt.Fprintf(f, "startxref\n%d\n%%%%EOF\n", first)
+		if !update {
+			continue
+		}
+		o5 := f.obj(5, "(updated 5)")
+		start := f.table([]xentry{{5, 1, o5, 0}}, fmt.Sprintf(" /Size 8 /Root 1 0 R /Prev %d", first))
+		fmt.Fprintf(f, "startxref\n%d\n%%%%EOF\n", start)
+	}
+	return f.Bytes()
+}
+
+func TestLinearizedRevisions(t *testing.T) {
+	for _, update := range []bool{false, true} {
+		r := openTest(t, linearizedFile(update), &ReaderOptions{Strict: true})
+		want := 1
+		if update {
+			want = 2
+		}
+		if n := len(r.Revisions()); n != want {
+			t.Errorf("update=%v: len(Revisions()) = %d, want %d", update, n, want)
+			continue
+		}
+		r0, err := r.AtRevision(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := r0.NumPage(); n != 1 {
+			t.Errorf("update=%v: AtRevision(0).NumPage() = %d, want 1", update, n)
+		}
+		checkObjects(t, r0, map[uint32]string{5: "original 5"})
+		if update {
+			checkObjects(t, r, map[uint32]string{5: "updated 5"})
+		}
+	}
+}
@@ -39,6 +39,29 @@ Decode:
 	return string(r)
 }
 
+// pdfDocEncode encodes s in PDFDocEncoding,
+// reporting whether every character in s can be encoded.
+func pdfDocEncode(s string) ([]byte, bool) {
+	b := make([]byte, 0, len(s))
+Runes:
+	for _, r := range s {
+		if r < 0x80 && pdfDocEncoding[r] == r {
+			b = append(b, byte(r))
+			continue
+		}
+		if r != noRune {
+			for i, x := range pdfDocEncoding {
+				if x == r {
+					b = append(b, byte(i))
+					continue Runes
+				}
+			}
+		}
+		return nil, false
+	}
+	return b, true
+}
+
 func isUTF16(s string) bool {
 	return len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff && len(s)%2 == 0
 }
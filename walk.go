@@ -0,0 +1,85 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Traversal of the graph of values.
+
+package pdf
+
+import (
+	"errors"
+	"strconv"
+)
+
+// SkipChildren is used as a return value from the visit function passed
+// to Walk to indicate that the entries of the value just visited are to be
+// skipped. It is not returned as an error by Walk.
+var SkipChildren = errors.New("skip children")
+
+// Walk calls visit for root and, recursively, for the entries of
+// each dictionary, stream dictionary, and array it contains, in order
+// of key or index. The path passed to visit lists the keys and indexes
+// leading from root to the value, so that for root r.Trailer(), the path
+// ["Root", "Pages", "Kids", "3", "Resources"] corresponds to the pointer
+// /Root/Pages/Kids/3/Resources. Visit must copy path to keep it.
+//
+// Walk follows each indirect reference only once: an indirect object
+// reached again, by another path or through a cycle, is not visited again.
+// If visit returns SkipChildren, Walk does not visit the value's entries.
+// If visit returns any other error, Walk stops and returns that error.
+// A value that cannot be loaded is visited as a null Value whose Err
+// method reports the problem. Limits.MaxResolves does not apply to Walk.
+func Walk(root Value, visit func(path []string, v Value) error) error {
+	w := &walker{r: root.r, seen: make(map[objptr]bool), visit: visit}
+	if root.ref {
+		w.seen[root.ptr] = true
+	}
+	return w.walkValue(nil, root)
+}
+
+// A walker holds the state of a call to Walk.
+type walker struct {
+	r     *Reader
+	seen  map[objptr]bool // indirect objects reached so far
+	visit func(path []string, v Value) error
+}
+
+func (w *walker) walkValue(path []string, v Value) error {
+	if err := w.visit(path, v); err != nil {
+		if err == SkipChildren {
+			return nil
+		}
+		return err
+	}
+	switch x := v.data.(type) {
+	case array:
+		for i, elem := range x {
+			if err := w.walkEntry(append(path, strconv.Itoa(i)), v.ptr, elem); err != nil {
+				return err
+			}
+		}
+	case dict, stream:
+		hdr, ok := x.(dict)
+		if !ok {
+			hdr = x.(stream).hdr
+		}
+		for _, key := range v.Keys() {
+			if err := w.walkEntry(append(path, key), v.ptr, hdr[name(key)]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walkEntry walks the entry x of a dictionary or array in the object parent,
+// unless x refers to an object that has been walked already.
+func (w *walker) walkEntry(path []string, parent objptr, x object) error {
+	if ptr, ok := x.(objptr); ok {
+		if w.seen[ptr] {
+			return nil
+		}
+		w.seen[ptr] = true
+	}
+	return w.walkValue(path, w.r.resolve(parent, x))
+}
@@ -0,0 +1,63 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Warnings about problems that a Reader works around.
+
+package pdf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A Warning describes a problem that a Reader worked around,
+// or content that it could not interpret and skipped.
+// Unlike errors, warnings do not cause any method to fail.
+type Warning struct {
+	// Category classifies the problem:
+	// "xref" for a damaged cross-reference table,
+	// "encryption" for a problem with the encryption dictionary,
+	// "font" for an unsupported font encoding,
+	// "cmap" for a ToUnicode CMap that cannot be used or applied.
+	Category string
+
+	ID      uint32 // number of the object concerned, or 0 if unknown
+	Gen     uint16 // generation of the object concerned
+	Offset  int64  // byte offset of the problem, or -1 if unknown
+	Message string
+}
+
+func (w Warning) String() string {
+	e := &Error{ID: w.ID, Gen: w.Gen, Offset: w.Offset, Err: errors.New(w.Message)}
+	return w.Category + ": " + e.Error()
+}
+
+// SetWarningHandler arranges for h to be called with each Warning
+// about the file. Warnings found while opening the file are only seen
+// by a handler installed using ReaderOptions.Warn.
+// By default warnings are discarded.
+// SetWarningHandler should be called before r is shared between goroutines.
+func (r *Reader) SetWarningHandler(h func(Warning)) {
+	r.warnh = h
+}
+
+// warnf reports a warning in the given category about the object ptr
+// to r's warning handler and logger, if any.
+func (r *Reader) warnf(category string, ptr objptr, offset int64, format string, args ...interface{}) {
+	if r == nil || r.warnh == nil && r.logger == nil {
+		return
+	}
+	w := Warning{category, ptr.id, ptr.gen, offset, fmt.Sprintf(format, args...)}
+	if r.warnh != nil {
+		r.warnh(w)
+	}
+	if r.logger != nil {
+		r.logger.Print(w)
+	}
+}
+
+// warnf reports a warning in the given category about the object containing v.
+func (v Value) warnf(category string, format string, args ...interface{}) {
+	v.r.warnf(category, v.ptr, -1, format, args...)
+}